@@ -0,0 +1,79 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// autoTuneBudgets are the spin budgets compared by AutoTune.
+var autoTuneBudgets = []int{0, 16, 64, 256, 1024}
+
+// autoTuneDuration is the duration each spin budget is measured by AutoTune.
+const autoTuneDuration = 20 * time.Millisecond
+
+// AutoTune measures the throughput of workload under contention on l for
+// several spin budgets and returns the budget with the best throughput.
+// Use SetSpinBudget to apply the returned budget.
+//
+// For each candidate budget, GOMAXPROCS goroutines (at least 2) repeatedly call
+// workload while holding l for a fixed duration. workload should be a
+// representative critical section of l. l must not be used by other goroutines
+// while AutoTune runs. The spin budget is global, thus other locks are affected
+// by the candidate budgets during the measurement as well. The previous spin
+// budget, or the default spin budget if it was not overridden, is restored
+// before AutoTune returns.
+func AutoTune(l *Mutex, workload func()) int {
+	prev := SpinBudget()
+	if atomic.LoadInt32(&spinBudgetSet) != 0 {
+		defer SetSpinBudget(prev)
+	} else {
+		// Keep following GOMAXPROCS and the CPU quota
+		defer SetSpinBudget(-1)
+	}
+
+	best, bestOps := prev, int64(-1)
+	for _, budget := range autoTuneBudgets {
+		SetSpinBudget(budget)
+		if ops := measureThroughput(l, workload, autoTuneDuration); ops > bestOps {
+			best, bestOps = budget, ops
+		}
+	}
+	return best
+}
+
+// measureThroughput returns the number of times workload could be called while
+// holding l by concurrent goroutines within d.
+func measureThroughput(l *Mutex, workload func(), d time.Duration) int64 {
+	goroutines := runtime.GOMAXPROCS(0)
+	if goroutines < 2 {
+		goroutines = 2
+	}
+
+	var ops int64
+	var stop int32
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var n int64
+			for atomic.LoadInt32(&stop) == 0 {
+				l.Lock()
+				workload()
+				l.Unlock()
+				n++
+			}
+			atomic.AddInt64(&ops, n)
+		}()
+	}
+	time.Sleep(d)
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+	return ops
+}
@@ -0,0 +1,61 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestAutoTune(t *testing.T) {
+	defer SetSpinBudget(-1)
+	SetSpinBudget(7)
+
+	var m Mutex
+	calls := 0
+	budget := AutoTune(&m, func() {
+		calls++
+		for i := 0; i < 100; i++ {
+		}
+	})
+
+	valid := false
+	for _, b := range autoTuneBudgets {
+		valid = valid || b == budget
+	}
+	if !valid {
+		t.Fatalf("AutoTune returned budget %d, which is not a candidate", budget)
+	}
+	if calls == 0 {
+		t.Fatal("workload was not called")
+	}
+	if b := SpinBudget(); b != 7 {
+		t.Fatalf("spin budget not restored: %d", b)
+	}
+	if !m.TryLock() {
+		t.Fatal("lock not released")
+	}
+	m.Unlock()
+
+	SetSpinBudget(budget)
+	if b := SpinBudget(); b != budget {
+		t.Fatalf("spin budget is %d after applying %d", b, budget)
+	}
+	HammerMutex(&m, 1000, make(chan bool, 1))
+}
+
+func TestAutoTuneDefaultBudget(t *testing.T) {
+	defer SetSpinBudget(-1)
+	SetSpinBudget(-1)
+
+	var m Mutex
+	AutoTune(&m, func() {})
+	if atomic.LoadInt32(&spinBudgetSet) != 0 {
+		t.Fatal("AutoTune overrode the default spin budget")
+	}
+	if b := SpinBudget(); b != DefaultSpinBudget() {
+		t.Fatalf("spin budget %d after AutoTune, expected the default %d", b, DefaultSpinBudget())
+	}
+}
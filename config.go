@@ -0,0 +1,48 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync/atomic"
+)
+
+// A Config holds an immutable snapshot of a value of type T, e.g. a reloadable
+// configuration, in read-copy-update style.
+// Readers never block and always see a complete snapshot, updates are
+// serialized by a Mutex.
+// The zero value for a Config holds the zero value of T.
+//
+// Values stored in a Config must not be modified after publication; T should
+// not contain references to mutable data shared with other snapshots.
+type Config[T any] struct {
+	mu      Mutex
+	current atomic.Pointer[T]
+}
+
+// NewConfig returns a Config holding initial.
+func NewConfig[T any](initial T) *Config[T] {
+	c := new(Config[T])
+	c.current.Store(&initial)
+	return c
+}
+
+// Get returns the current snapshot.
+func (c *Config[T]) Get() T {
+	if p := c.current.Load(); p != nil {
+		return *p
+	}
+	var zero T
+	return zero
+}
+
+// Update replaces the current snapshot with the value returned by fn, which is
+// called with the current snapshot. Concurrent updates are serialized, thus no
+// update is lost.
+func (c *Config[T]) Update(fn func(old T) T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	next := fn(c.Get())
+	c.current.Store(&next)
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type testConfig struct {
+	Version  int
+	Checksum int // Always 2 * Version
+	Names    []string
+}
+
+func TestConfig(t *testing.T) {
+	var zero Config[int]
+	if v := zero.Get(); v != 0 {
+		t.Fatalf("zero Config holds %d", v)
+	}
+	zero.Update(func(old int) int { return old + 1 })
+	if v := zero.Get(); v != 1 {
+		t.Fatalf("Config holds %d after Update, expected 1", v)
+	}
+
+	c := NewConfig(testConfig{Version: 1, Checksum: 2})
+	if v := c.Get(); v.Version != 1 {
+		t.Fatalf("Config holds version %d, expected 1", v.Version)
+	}
+}
+
+func TestConfigConcurrent(t *testing.T) {
+	const updaters = 4
+	const updates = 1000
+
+	c := NewConfig(testConfig{})
+	var stop int32
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				v := c.Get()
+				if v.Checksum != 2*v.Version || len(v.Names) != v.Version {
+					t.Errorf("inconsistent snapshot: %+v", v)
+					return
+				}
+			}
+		}()
+	}
+
+	var updatersWg sync.WaitGroup
+	for i := 0; i < updaters; i++ {
+		updatersWg.Add(1)
+		go func() {
+			defer updatersWg.Done()
+			for j := 0; j < updates; j++ {
+				c.Update(func(old testConfig) testConfig {
+					names := append(old.Names[:len(old.Names):len(old.Names)], "x")
+					return testConfig{
+						Version:  old.Version + 1,
+						Checksum: 2 * (old.Version + 1),
+						Names:    names,
+					}
+				})
+			}
+		}()
+	}
+	updatersWg.Wait()
+	atomic.StoreInt32(&stop, 1)
+	readers.Wait()
+
+	if v := c.Get(); v.Version != updaters*updates {
+		t.Fatalf("lost updates: version is %d, expected %d", v.Version, updaters*updates)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// contentionStackDepth is the maximum number of frames recorded per stack.
+const contentionStackDepth = 8
+
+var (
+	contentionProfiling int32
+
+	contentionMu     sync.Mutex
+	contentionCounts map[[contentionStackDepth]uintptr]int
+)
+
+// A StackContention is the number of contended lock acquisitions made from one
+// call stack.
+type StackContention struct {
+	// Stack holds the program counters of the acquiring call stack, starting
+	// with the caller of the lock method. Use runtime.CallersFrames to
+	// translate them.
+	Stack []uintptr
+	Count int
+}
+
+// SetContentionProfiling enables or disables recording of contended lock
+// acquisitions by call stack. Disabling it discards the recorded data.
+// It only has an effect in debug builds (built with the spinlockdebug tag).
+func SetContentionProfiling(enabled bool) {
+	contentionMu.Lock()
+	if enabled {
+		contentionCounts = make(map[[contentionStackDepth]uintptr]int)
+		atomic.StoreInt32(&contentionProfiling, 1)
+	} else {
+		atomic.StoreInt32(&contentionProfiling, 0)
+		contentionCounts = nil
+	}
+	contentionMu.Unlock()
+}
+
+// ContentionByStack returns the recorded contended lock acquisitions,
+// aggregated by the acquiring call stack and sorted by descending count.
+func ContentionByStack() []StackContention {
+	contentionMu.Lock()
+	defer contentionMu.Unlock()
+
+	res := make([]StackContention, 0, len(contentionCounts))
+	for stack, count := range contentionCounts {
+		n := 0
+		for n < len(stack) && stack[n] != 0 {
+			n++
+		}
+		res = append(res, StackContention{
+			Stack: append([]uintptr(nil), stack[:n]...),
+			Count: count,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Count > res[j].Count
+	})
+	return res
+}
+
+// recordContention records a contended acquisition if contention profiling is
+// enabled. It must be called directly by the slow path of a lock method.
+func recordContention() {
+	if !debug || atomic.LoadInt32(&contentionProfiling) == 0 {
+		return
+	}
+
+	var stack [contentionStackDepth]uintptr
+	// Skip runtime.Callers, recordContention, the slow path and the lock
+	// method itself
+	runtime.Callers(4, stack[:])
+
+	contentionMu.Lock()
+	if contentionCounts != nil {
+		contentionCounts[stack]++
+	}
+	contentionMu.Unlock()
+}
@@ -0,0 +1,97 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build spinlockdebug
+
+package spinlock
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+//go:noinline
+func contendA(m *Mutex) {
+	m.Lock()
+	m.Unlock()
+}
+
+//go:noinline
+func contendB(m *Mutex) {
+	m.Lock()
+	m.Unlock()
+}
+
+func totalContention() (total int) {
+	for _, c := range ContentionByStack() {
+		total += c.Count
+	}
+	return total
+}
+
+// contentionAt returns the number of contended acquisitions whose stack
+// contains a function with the given name suffix.
+func contentionAt(suffix string) (count int) {
+	for _, c := range ContentionByStack() {
+		frames := runtime.CallersFrames(c.Stack)
+		for {
+			frame, more := frames.Next()
+			if strings.HasSuffix(frame.Function, suffix) {
+				count += c.Count
+				break
+			}
+			if !more {
+				break
+			}
+		}
+	}
+	return count
+}
+
+func TestContentionByStack(t *testing.T) {
+	SetContentionProfiling(true)
+	defer SetContentionProfiling(false)
+
+	var m Mutex
+	contend := func(fn func(*Mutex)) {
+		m.Lock()
+		before := totalContention()
+		done := make(chan bool)
+		go func() {
+			fn(&m)
+			done <- true
+		}()
+		for totalContention() == before {
+			runtime.Gosched()
+		}
+		m.Unlock()
+		<-done
+	}
+
+	for i := 0; i < 2; i++ {
+		contend(contendA)
+	}
+	for i := 0; i < 3; i++ {
+		contend(contendB)
+	}
+
+	if n := contentionAt(".contendA"); n != 2 {
+		t.Errorf("recorded %d contended acquisitions at contendA, expected 2", n)
+	}
+	if n := contentionAt(".contendB"); n != 3 {
+		t.Errorf("recorded %d contended acquisitions at contendB, expected 3", n)
+	}
+
+	// Uncontended acquisitions are not recorded
+	contendA(&m)
+	if n := totalContention(); n != 5 {
+		t.Errorf("recorded %d contended acquisitions in total, expected 5", n)
+	}
+
+	SetContentionProfiling(false)
+	if n := len(ContentionByStack()); n != 0 {
+		t.Errorf("%d stacks recorded after disabling", n)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// A WaitAccumulator accumulates the time spent waiting for locks by the
+// context-aware lock methods, e.g. for attributing lock contention to a
+// request. It is safe for concurrent use.
+type WaitAccumulator struct {
+	wait      int64 // Accumulated waiting time in nanoseconds
+	contended int64 // Number of contended acquisitions
+}
+
+type waitAccumulatorKey struct{}
+
+// WithWaitAccumulator returns a copy of ctx carrying a new WaitAccumulator,
+// which is also returned.
+// Lock methods called with the returned context or a context derived from it,
+// such as RWMutex.LockContext, add their waiting time to the accumulator.
+func WithWaitAccumulator(ctx context.Context) (context.Context, *WaitAccumulator) {
+	a := new(WaitAccumulator)
+	return context.WithValue(ctx, waitAccumulatorKey{}, a), a
+}
+
+// WaitAccumulatorFrom returns the WaitAccumulator carried by ctx or nil.
+func WaitAccumulatorFrom(ctx context.Context) *WaitAccumulator {
+	a, _ := ctx.Value(waitAccumulatorKey{}).(*WaitAccumulator)
+	return a
+}
+
+// Wait returns the accumulated time spent waiting for locks.
+func (a *WaitAccumulator) Wait() time.Duration {
+	return time.Duration(atomic.LoadInt64(&a.wait))
+}
+
+// Contended returns the number of lock acquisitions which had to wait,
+// including acquisitions aborted because the context was done.
+func (a *WaitAccumulator) Contended() int64 {
+	return atomic.LoadInt64(&a.contended)
+}
+
+// accountWait adds the time since start to the WaitAccumulator of ctx, if any.
+// Only contended acquisitions are accounted, the uncontended fast paths do not
+// pay for the context lookup.
+func accountWait(ctx context.Context, start time.Time) {
+	if a := WaitAccumulatorFrom(ctx); a != nil {
+		atomic.AddInt64(&a.wait, int64(time.Since(start)))
+		atomic.AddInt64(&a.contended, 1)
+	}
+}
+
+// RLockContext locks rw for reading like RLock, but stops waiting when ctx is
+// done. In that case the lock is not acquired and ctx.Err() is returned.
+// The waiting time is added to the WaitAccumulator of ctx, if any.
+func (rw *RWMutex) RLockContext(ctx context.Context) error {
+	rw.dbg.acquire()
+	state := atomic.AddUint32(&rw.state, rwmutexReadOffset)
+	if state&rwmutexWrite != 0 {
+		if err := rw.rlockContextSlow(ctx); err != nil {
+			return err
+		}
+	}
+	rw.dbg.acquiredRead()
+	return nil
+}
+
+func (rw *RWMutex) rlockContextSlow(ctx context.Context) error {
+	defer accountWait(ctx, time.Now())
+	for {
+		for i := spinAttempts(); i > 0; i-- {
+			if atomic.LoadUint32(&rw.state)&rwmutexWrite == 0 {
+				return nil
+			}
+			spinPause()
+		}
+		if err := ctx.Err(); err != nil {
+			// Undo
+			atomic.AddUint32(&rw.state, rwmutexReaderDecrease)
+			return err
+		}
+		yield()
+		if atomic.LoadUint32(&rw.state)&rwmutexWrite == 0 {
+			return nil
+		}
+	}
+}
+
+// LockContext locks rw for writing like Lock, but stops waiting when ctx is
+// done. In that case the lock is not acquired and ctx.Err() is returned.
+// The waiting time is added to the WaitAccumulator of ctx, if any.
+func (rw *RWMutex) LockContext(ctx context.Context) error {
+	rw.dbg.acquireWrite()
+	if !atomic.CompareAndSwapUint32(&rw.state, rwmutexUnlocked, rwmutexWrite) {
+		if err := rw.lockContextSlow(ctx); err != nil {
+			return err
+		}
+	}
+	rw.dbg.acquiredWrite()
+	return nil
+}
+
+func (rw *RWMutex) lockContextSlow(ctx context.Context) error {
+	defer accountWait(ctx, time.Now())
+	for !rw.spinTryLock(spinAttempts()) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		yield()
+	}
+	return nil
+}
+
+const (
+	lockCtxMinSleep = time.Microsecond // Initial sleep of LockCtx with a deadline
+	lockCtxMaxSleep = time.Millisecond // Maximum sleep of LockCtx with a deadline
+)
+
+// LockCtx locks m like Lock, but stops waiting when ctx is done. In that case
+// the lock is not acquired and ctx.Err() is returned.
+// If ctx has a deadline, which may be far in the future, LockCtx spins only
+// briefly and then sleeps between attempts to acquire the lock, doubling the
+// sleep up to 1ms. Otherwise it waits like Lock, spinning and yielding the
+// processor, and checks for cancellation in between.
+// The waiting time is added to the WaitAccumulator of ctx, if any.
+func (m *Mutex) LockCtx(ctx context.Context) error {
+	m.dbg.acquire()
+	if !atomic.CompareAndSwapInt32(&m.state, mutexUnlocked, mutexLocked) {
+		if err := m.lockCtxSlow(ctx); err != nil {
+			return err
+		}
+	}
+	m.dbg.acquired()
+	return nil
+}
+
+func (m *Mutex) lockCtxSlow(ctx context.Context) error {
+	defer accountWait(ctx, time.Now())
+	if _, ok := ctx.Deadline(); !ok {
+		for !m.spinTryLock(spinAttempts()) {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			yield()
+		}
+		return nil
+	}
+
+	if m.spinTryLock(spinAttempts()) {
+		return nil
+	}
+	timer := time.NewTimer(lockCtxMinSleep)
+	defer timer.Stop()
+	for d := lockCtxMinSleep; ; {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if atomic.CompareAndSwapInt32(&m.state, mutexUnlocked, mutexLocked) {
+			return nil
+		}
+		if d < lockCtxMaxSleep {
+			if d *= 2; d > lockCtxMaxSleep {
+				d = lockCtxMaxSleep
+			}
+		}
+		timer.Reset(d)
+	}
+}
+
+// WithRLockContext locks rw for reading using RLockContext, calls fn and
+// unlocks rw again, even if fn panics. It returns the error of RLockContext or
+// fn. The signature fits errgroup-style task runners:
+//
+//	g, ctx := errgroup.WithContext(ctx)
+//	g.Go(func() error {
+//		return rw.WithRLockContext(ctx, func() error {
+//			...
+//		})
+//	})
+//
+// Once the group's context is cancelled, goroutines waiting for rw return
+// promptly instead of waiting for the lock.
+func (rw *RWMutex) WithRLockContext(ctx context.Context, fn func() error) error {
+	if err := rw.RLockContext(ctx); err != nil {
+		return err
+	}
+	defer rw.RUnlock()
+	return fn()
+}
+
+// WithLockContext is like WithRLockContext, but locks rw for writing.
+func (rw *RWMutex) WithLockContext(ctx context.Context, fn func() error) error {
+	if err := rw.LockContext(ctx); err != nil {
+		return err
+	}
+	defer rw.Unlock()
+	return fn()
+}
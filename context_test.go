@@ -0,0 +1,230 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRWMutexLockContext(t *testing.T) {
+	var rw RWMutex
+	ctx := context.Background()
+	if err := rw.RLockContext(ctx); err != nil {
+		t.Fatalf("RLockContext failed: %v", err)
+	}
+	if err := rw.RLockContext(ctx); err != nil {
+		t.Fatalf("second RLockContext failed: %v", err)
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+	if err := rw.LockContext(ctx); err != nil {
+		t.Fatalf("LockContext failed: %v", err)
+	}
+
+	// Wait for the lock until the writer releases it
+	done := make(chan error)
+	go func() { done <- rw.RLockContext(ctx) }()
+	time.Sleep(time.Millisecond)
+	rw.Unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("RLockContext failed: %v", err)
+	}
+	rw.RUnlock()
+}
+
+func TestRWMutexLockContextCancel(t *testing.T) {
+	// Simulates an errgroup whose context is cancelled while its goroutines
+	// wait for the lock
+	var rw RWMutex
+	rw.Lock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(write bool) {
+			defer wg.Done()
+			fn := func() error {
+				t.Error("fn called without holding the lock")
+				return nil
+			}
+			if write {
+				errs <- rw.WithLockContext(ctx, fn)
+			} else {
+				errs <- rw.WithRLockContext(ctx, fn)
+			}
+		}(i%2 == 0)
+	}
+	time.Sleep(5 * time.Millisecond)
+	cancel()
+
+	stopped := make(chan bool)
+	go func() {
+		wg.Wait()
+		stopped <- true
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("waiting goroutines did not stop after cancellation")
+	}
+	for i := 0; i < 8; i++ {
+		if err := <-errs; !errors.Is(err, context.Canceled) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	// The readers must have been rolled back
+	if rw.state != rwmutexWrite {
+		t.Fatalf("state after cancellation is %#x, expected write-locked only", rw.state)
+	}
+	rw.Unlock()
+	if err := rw.WithLockContext(context.Background(), func() error { return nil }); err != nil {
+		t.Fatalf("WithLockContext failed: %v", err)
+	}
+
+	// Cancelled contexts do not prevent acquiring a free lock
+	if err := rw.RLockContext(ctx); err != nil {
+		t.Fatalf("RLockContext of free lock failed: %v", err)
+	}
+	rw.RUnlock()
+}
+
+func TestMutexLockCtx(t *testing.T) {
+	var m Mutex
+	if err := m.LockCtx(context.Background()); err != nil {
+		t.Fatalf("LockCtx of free lock failed: %v", err)
+	}
+
+	// Short deadline
+	const timeout = 20 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	if err := m.LockCtx(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("LockCtx returned %v while locked, expected %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d < timeout || d > time.Second {
+		t.Fatalf("LockCtx timed out after %v, expected about %v", d, timeout)
+	}
+
+	// Cancellable context without deadline
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(5*time.Millisecond, cancel)
+	if err := m.LockCtx(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("LockCtx returned %v while locked, expected %v", err, context.Canceled)
+	}
+
+	// A long deadline does not delay the acquisition much once the lock is free
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ctx, acc := WithWaitAccumulator(ctx)
+	time.AfterFunc(5*time.Millisecond, m.Unlock)
+	start = time.Now()
+	if err := m.LockCtx(ctx); err != nil {
+		t.Fatalf("LockCtx failed after release: %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("LockCtx acquired the lock after %v", d)
+	}
+	if acc.Contended() != 1 {
+		t.Fatalf("%d contended acquisitions accounted, expected 1", acc.Contended())
+	}
+	m.Unlock()
+}
+
+func TestWaitAccumulator(t *testing.T) {
+	const hold = 5 * time.Millisecond
+
+	if WaitAccumulatorFrom(context.Background()) != nil {
+		t.Fatal("WaitAccumulator in background context")
+	}
+	ctx, acc := WithWaitAccumulator(context.Background())
+	if WaitAccumulatorFrom(ctx) != acc {
+		t.Fatal("WaitAccumulator not carried by context")
+	}
+
+	// Uncontended acquisitions are not accounted
+	var rw RWMutex
+	rw.LockContext(ctx)
+	rw.Unlock()
+	rw.RLockContext(ctx)
+	rw.RUnlock()
+	if acc.Wait() != 0 || acc.Contended() != 0 {
+		t.Fatalf("uncontended acquisitions accounted: %v, %d", acc.Wait(), acc.Contended())
+	}
+
+	var measured time.Duration
+	for i := 0; i < 4; i++ {
+		rw.Lock()
+		done := make(chan time.Duration)
+		go func(read bool) {
+			start := time.Now()
+			if read {
+				rw.RLockContext(ctx)
+				rw.RUnlock()
+			} else {
+				rw.LockContext(ctx)
+				rw.Unlock()
+			}
+			done <- time.Since(start)
+		}(i%2 == 0)
+		time.Sleep(hold)
+		rw.Unlock()
+		measured += <-done
+	}
+
+	if n := acc.Contended(); n != 4 {
+		t.Fatalf("%d contended acquisitions accounted, expected 4", n)
+	}
+	if wait := acc.Wait(); wait > measured || wait < measured/2 {
+		t.Fatalf("accounted waiting time %v does not match the measured %v", wait, measured)
+	}
+}
+
+func TestRLockerFull(t *testing.T) {
+	var rw RWMutex
+	rl := rw.RLocker()
+
+	if !rl.TryLock() {
+		t.Fatal("TryLock failed while unlocked")
+	}
+	if !rl.TryLock() {
+		t.Fatal("TryLock failed while read-locked")
+	}
+	if rw.TryLock() {
+		t.Fatal("write lock acquired while read-locked through RLocker")
+	}
+	rl.Unlock()
+	rl.Unlock()
+
+	rw.Lock()
+	if rl.TryLock() {
+		t.Fatal("TryLock succeeded while write-locked")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := rl.LockContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("LockContext returned %v while write-locked, expected %v", err, context.DeadlineExceeded)
+	}
+	rw.Unlock()
+
+	if err := rl.LockContext(context.Background()); err != nil {
+		t.Fatalf("LockContext failed while unlocked: %v", err)
+	}
+	if rw.TryLock() {
+		t.Fatal("write lock acquired while read-locked through LockContext")
+	}
+	rl.Unlock()
+	if !rw.TryLock() {
+		t.Fatal("read lock not released by Unlock")
+	}
+	rw.Unlock()
+}
@@ -0,0 +1,62 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync/atomic"
+)
+
+// A CountedMutex is a mutual exclusion lock which can be re-entered by the
+// goroutine holding it, without the overhead of tracking the holding
+// goroutine. The caller is trusted: Reenter must only be called by the holder
+// of the lock and calls must be balanced by Unlock calls.
+// CountedMutex provides no protection against misuse from other goroutines;
+// a Reenter call by a goroutine not holding the lock breaks mutual exclusion.
+// The zero value for a CountedMutex is an unlocked mutex.
+type CountedMutex struct {
+	m     Mutex
+	depth int32
+}
+
+// Lock locks m with a depth of 1.
+// If the lock is already in use, the calling goroutine busy waits until the
+// lock is available. Lock must not be used to re-enter the lock, use Reenter.
+func (m *CountedMutex) Lock() {
+	m.m.Lock()
+	atomic.StoreInt32(&m.depth, 1)
+}
+
+// Reenter re-enters m, which must be held by the calling goroutine, by
+// increasing the depth by 1.
+// It is a run-time error if m is not locked on entry to Reenter.
+func (m *CountedMutex) Reenter() {
+	for {
+		depth := atomic.LoadInt32(&m.depth)
+		if depth <= 0 {
+			panic("spinlock: Reenter of unlocked CountedMutex")
+		}
+		if atomic.CompareAndSwapInt32(&m.depth, depth, depth+1) {
+			return
+		}
+	}
+}
+
+// Depth returns the current depth of m. It is 0 if m is not locked.
+func (m *CountedMutex) Depth() int {
+	return int(atomic.LoadInt32(&m.depth))
+}
+
+// Unlock decreases the depth of m by 1 and unlocks m at a depth of 0.
+// It is a run-time error if m is not locked on entry to Unlock.
+func (m *CountedMutex) Unlock() {
+	depth := atomic.AddInt32(&m.depth, -1)
+	switch {
+	case depth == 0:
+		m.m.Unlock()
+	case depth < 0:
+		atomic.AddInt32(&m.depth, 1)
+		panic("spinlock: unlock of unlocked CountedMutex")
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"testing"
+)
+
+func TestCountedMutex(t *testing.T) {
+	var m CountedMutex
+	m.Lock()
+	m.Reenter()
+	m.Reenter()
+	if d := m.Depth(); d != 3 {
+		t.Fatalf("depth %d, expected 3", d)
+	}
+
+	locked := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		m.Lock()
+		locked <- true
+		m.Unlock()
+		done <- true
+	}()
+	for d := 3; d > 0; d-- {
+		select {
+		case <-locked:
+			t.Fatalf("lock released at depth %d", d)
+		default:
+		}
+		m.Unlock()
+	}
+	<-locked
+	<-done
+	if d := m.Depth(); d != 0 {
+		t.Fatalf("depth %d after full release, expected 0", d)
+	}
+}
+
+func TestCountedMutexPanic(t *testing.T) {
+	var m CountedMutex
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("Reenter of unlocked CountedMutex did not panic")
+			}
+		}()
+		m.Reenter()
+	}()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("unlock of unlocked CountedMutex did not panic")
+			}
+		}()
+		m.Unlock()
+	}()
+	if d := m.Depth(); d != 0 {
+		t.Fatalf("depth %d after failed Unlock, expected 0", d)
+	}
+}
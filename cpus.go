@@ -0,0 +1,123 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// effectiveCPUsRefresh is the interval in which EffectiveCPUs re-reads the
+// CPU quota.
+const effectiveCPUsRefresh = time.Second
+
+// cgroupRoot is the mount point of the cgroup file system.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupRootOverride replaces cgroupRoot in tests. It is read by the
+// background refresh of the spin budget, thus it is accessed atomically.
+var cgroupRootOverride atomic.Pointer[string]
+
+// cgroupRootDir returns the mount point of the cgroup file system.
+func cgroupRootDir() string {
+	if root := cgroupRootOverride.Load(); root != nil {
+		return *root
+	}
+	return cgroupRoot
+}
+
+var (
+	cpuQuota       uint64 // math.Float64bits of the quota, 0 if none
+	cpuQuotaExpiry int64  // Unix time in nanoseconds
+)
+
+// EffectiveCPUs returns an estimate of the number of CPUs the program can run
+// on in parallel. It is GOMAXPROCS, limited by the CPU quota of the cgroup of
+// the process, if any. In throttled containers GOMAXPROCS may exceed the CPU
+// quota, making busy waiting harmful.
+// The quota is read from the cgroup file system (v2 or v1) on Linux and
+// refreshed at most once per second. The default spin budget is derived from
+// it, see DefaultSpinBudget.
+func EffectiveCPUs() int {
+	quota := math.Float64frombits(atomic.LoadUint64(&cpuQuota))
+	if now := time.Now().UnixNano(); now >= atomic.LoadInt64(&cpuQuotaExpiry) {
+		var ok bool
+		if quota, ok = cgroupCPUQuota(cgroupRootDir()); !ok {
+			quota = 0
+		}
+		atomic.StoreUint64(&cpuQuota, math.Float64bits(quota))
+		atomic.StoreInt64(&cpuQuotaExpiry, now+int64(effectiveCPUsRefresh))
+	}
+	return limitCPUs(runtime.GOMAXPROCS(0), quota, quota > 0)
+}
+
+// limitCPUs limits procs by the CPU quota, rounded up, if ok is true.
+func limitCPUs(procs int, quota float64, ok bool) int {
+	if ok {
+		if limit := int(math.Ceil(quota)); limit < procs {
+			procs = limit
+		}
+	}
+	if procs < 1 {
+		return 1
+	}
+	return procs
+}
+
+// cgroupCPUQuota returns the CPU quota in CPUs read from the cgroup file system
+// mounted at root. ok is false if no quota is set or it could not be read.
+func cgroupCPUQuota(root string) (quota float64, ok bool) {
+	// cgroup v2
+	if b, err := os.ReadFile(filepath.Join(root, "cpu.max")); err == nil {
+		return parseCPUMax(string(b))
+	}
+	// cgroup v1
+	for _, dir := range []string{"cpu", "cpu,cpuacct"} {
+		q, err := os.ReadFile(filepath.Join(root, dir, "cpu.cfs_quota_us"))
+		if err != nil {
+			continue
+		}
+		p, err := os.ReadFile(filepath.Join(root, dir, "cpu.cfs_period_us"))
+		if err != nil {
+			continue
+		}
+		return parseCFSQuota(string(q), string(p))
+	}
+	return 0, false
+}
+
+// parseCPUMax parses the content of the cgroup v2 cpu.max file, which is
+// "$MAX $PERIOD", where $MAX is "max" if there is no limit.
+func parseCPUMax(s string) (quota float64, ok bool) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0, false
+	}
+	return parseQuota(fields[0], fields[1])
+}
+
+// parseCFSQuota parses the content of the cgroup v1 cpu.cfs_quota_us and
+// cpu.cfs_period_us files. A quota of -1 means there is no limit.
+func parseCFSQuota(quota, period string) (float64, bool) {
+	return parseQuota(strings.TrimSpace(quota), strings.TrimSpace(period))
+}
+
+func parseQuota(quota, period string) (float64, bool) {
+	q, err := strconv.ParseInt(quota, 10, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseInt(period, 10, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return float64(q) / float64(p), true
+}
@@ -0,0 +1,185 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseCPUMax(t *testing.T) {
+	for _, test := range []struct {
+		in    string
+		quota float64
+		ok    bool
+	}{
+		{"max 100000\n", 0, false},
+		{"100000 100000\n", 1, true},
+		{"150000 100000\n", 1.5, true},
+		{"50000 100000", 0.5, true},
+		{"", 0, false},
+		{"100000", 0, false},
+		{"x 100000", 0, false},
+		{"100000 0", 0, false},
+	} {
+		if quota, ok := parseCPUMax(test.in); quota != test.quota || ok != test.ok {
+			t.Errorf("parseCPUMax(%q) = %v, %v, expected %v, %v", test.in, quota, ok, test.quota, test.ok)
+		}
+	}
+}
+
+func TestParseCFSQuota(t *testing.T) {
+	for _, test := range []struct {
+		quota, period string
+		cpus          float64
+		ok            bool
+	}{
+		{"-1\n", "100000\n", 0, false},
+		{"200000\n", "100000\n", 2, true},
+		{"25000", "100000", 0.25, true},
+		{"", "100000", 0, false},
+		{"100000", "", 0, false},
+	} {
+		if cpus, ok := parseCFSQuota(test.quota, test.period); cpus != test.cpus || ok != test.ok {
+			t.Errorf("parseCFSQuota(%q, %q) = %v, %v, expected %v, %v",
+				test.quota, test.period, cpus, ok, test.cpus, test.ok)
+		}
+	}
+}
+
+func TestLimitCPUs(t *testing.T) {
+	for _, test := range []struct {
+		procs int
+		quota float64
+		ok    bool
+		cpus  int
+	}{
+		{4, 0, false, 4},
+		{4, 2, true, 2},
+		{4, 1.5, true, 2},
+		{4, 0.1, true, 1},
+		{4, 8, true, 4},
+	} {
+		if cpus := limitCPUs(test.procs, test.quota, test.ok); cpus != test.cpus {
+			t.Errorf("limitCPUs(%d, %v, %v) = %d, expected %d", test.procs, test.quota, test.ok, cpus, test.cpus)
+		}
+	}
+}
+
+// setCgroupRoot makes EffectiveCPUs read the cgroup file system from root.
+func setCgroupRoot(t *testing.T, root string) {
+	prev := cgroupRootOverride.Swap(&root)
+	atomic.StoreInt64(&cpuQuotaExpiry, 0)
+	t.Cleanup(func() {
+		cgroupRootOverride.Store(prev)
+		atomic.StoreInt64(&cpuQuotaExpiry, 0)
+	})
+}
+
+// refreshSpinBudgetNow recomputes the default spin budget like the background
+// refresh does.
+func refreshSpinBudgetNow() {
+	spinBudgetMu.Lock()
+	gen := spinBudgetGen
+	spinBudgetMu.Unlock()
+	refreshSpinBudget(gen)
+}
+
+func TestEffectiveCPUs(t *testing.T) {
+	// Restore the spin budget after the cgroup root and GOMAXPROCS
+	t.Cleanup(func() { SetSpinBudget(-1) })
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	// No cgroup quota
+	setCgroupRoot(t, t.TempDir())
+	if n := EffectiveCPUs(); n != 4 {
+		t.Fatalf("EffectiveCPUs() = %d without quota, expected GOMAXPROCS", n)
+	}
+	refreshSpinBudgetNow()
+	if budget := SpinBudget(); budget != spinBudgetFor(4) {
+		t.Fatalf("default spin budget %d without quota, expected %d", budget, spinBudgetFor(4))
+	}
+
+	// cgroup v2 quota of 1 CPU, lower than GOMAXPROCS
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "cpu.max"), []byte("100000 100000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setCgroupRoot(t, root)
+	if n := EffectiveCPUs(); n != 1 {
+		t.Fatalf("EffectiveCPUs() = %d with a quota of 1 CPU, expected 1", n)
+	}
+	refreshSpinBudgetNow()
+	if budget := SpinBudget(); budget != 0 {
+		t.Fatalf("default spin budget %d with a quota of 1 CPU, expected no spinning", budget)
+	}
+
+	// cgroup v1 quota of 2 CPUs
+	root = t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "cpu,cpuacct"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"cpu.cfs_quota_us":  "200000\n",
+		"cpu.cfs_period_us": "100000\n",
+	} {
+		if err := os.WriteFile(filepath.Join(root, "cpu,cpuacct", name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setCgroupRoot(t, root)
+	if n := EffectiveCPUs(); n != 2 {
+		t.Fatalf("EffectiveCPUs() = %d with a quota of 2 CPUs, expected 2", n)
+	}
+	refreshSpinBudgetNow()
+	if budget := SpinBudget(); budget != spinBudgetFor(2) {
+		t.Fatalf("default spin budget %d with a quota of 2 CPUs, expected %d", budget, spinBudgetFor(2))
+	}
+
+	// An overridden spin budget does not follow the quota
+	SetSpinBudget(100)
+	setCgroupRoot(t, t.TempDir())
+	refreshSpinBudgetNow()
+	if budget := SpinBudget(); budget != 100 {
+		t.Fatalf("overridden spin budget changed to %d", budget)
+	}
+	SetSpinBudget(-1)
+	setCgroupRoot(t, root)
+
+	// The quota is cached
+	setCgroupRoot(t, t.TempDir())
+	EffectiveCPUs()
+	cgroupRootOverride.Store(&root)
+	if n := EffectiveCPUs(); n != 4 {
+		t.Fatalf("EffectiveCPUs() = %d, expected the cached value 4", n)
+	}
+}
+
+func TestSpinBudgetBackgroundRefresh(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the background refresh")
+	}
+	t.Cleanup(func() { SetSpinBudget(-1) })
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	SetSpinBudget(-1)
+
+	// cgroup v2 quota of 1 CPU
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "cpu.max"), []byte("100000 100000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setCgroupRoot(t, root)
+	deadline := time.Now().Add(5 * effectiveCPUsRefresh)
+	for SpinBudget() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("default spin budget %d not refreshed, expected no spinning", SpinBudget())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build spinlockdebug
+
+package spinlock
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// debug enables the debug checks and tooling of the package.
+// It is set by building with the spinlockdebug build tag; in regular builds
+// all debug code is compiled out.
+const debug = true
+
+// lockDebug is the debug state of a lock.
+// It must be the first field of the lock, so that its address identifies the
+// lock.
+type lockDebug struct {
+	level   int
+	leveled bool
+}
+
+type mutexDebug struct {
+	lockDebug
+	hold holdWatch
+
+	spinAcquired  uint64 // Contended Lock acquisitions without yielding
+	yieldAcquired uint64 // Contended Lock acquisitions after yielding
+
+	acquiredAt time.Time // Zero while unlocked
+	site       callSite  // Call stack of the current acquisition
+
+	maxMu   sync.Mutex
+	maxHold time.Duration // Longest critical section so far
+	maxSite callSite
+}
+
+// A callSite is the call stack of a lock acquisition, starting at the debug
+// hook recording it.
+type callSite [8]uintptr
+
+// caller returns the position of the first call outside the methods of Mutex.
+func (s *callSite) caller() (file string, line int) {
+	n := 0
+	for n < len(s) && s[n] != 0 {
+		n++
+	}
+	frames := runtime.CallersFrames(s[:n])
+	hook, more := frames.Next()
+	// The package path is taken from the name of the hook
+	prefix := hook.Function[:strings.LastIndex(hook.Function, ".(*mutexDebug).")] + ".(*Mutex)."
+	for more {
+		var f runtime.Frame
+		f, more = frames.Next()
+		if !strings.HasPrefix(f.Function, prefix) {
+			return f.File, f.Line
+		}
+	}
+	return "", 0
+}
+
+type rwmutexDebug struct {
+	lockDebug
+	owner uint64 // ID of the goroutine holding the write lock
+	hold  holdWatch
+
+	ownedReads bool
+	readersMu  sync.Mutex
+	readers    map[uint64]int // Number of read locks held per goroutine
+}
+
+type heldLock struct {
+	lock  *lockDebug
+	level int
+}
+
+var (
+	heldMu sync.Mutex
+	held   = make(map[uint64][]heldLock) // Leveled locks held per goroutine
+)
+
+func (d *lockDebug) setLevel(level int) {
+	d.level = level
+	d.leveled = true
+}
+
+// acquire must be called before blocking to acquire the lock.
+// It panics if the calling goroutine holds a lock of a higher or equal level.
+func (d *lockDebug) acquire() {
+	if !d.leveled {
+		return
+	}
+	id := goid()
+	heldMu.Lock()
+	defer heldMu.Unlock()
+	for _, h := range held[id] {
+		if h.level >= d.level {
+			panic(fmt.Sprintf("spinlock: lock order violation: acquiring lock of level %d while holding lock of level %d",
+				d.level, h.level))
+		}
+	}
+}
+
+// acquired must be called after the lock was acquired.
+func (d *lockDebug) acquired() {
+	if !d.leveled {
+		return
+	}
+	id := goid()
+	heldMu.Lock()
+	held[id] = append(held[id], heldLock{lock: d, level: d.level})
+	heldMu.Unlock()
+}
+
+// release must be called when the lock is released.
+func (d *lockDebug) release() {
+	if !d.leveled {
+		return
+	}
+	id := goid()
+	heldMu.Lock()
+	defer heldMu.Unlock()
+	// Locks may be released by another goroutine than the one holding it.
+	// Prefer the releasing goroutine.
+	if removeHeld(id, d) {
+		return
+	}
+	for id := range held {
+		if removeHeld(id, d) {
+			return
+		}
+	}
+}
+
+// record records a lock event of the lock, identified by the address of d.
+func (d *lockDebug) record(kind LockEventKind) {
+	recordLockEvent(kind, uintptr(unsafe.Pointer(d)))
+}
+
+func removeHeld(id uint64, lock *lockDebug) bool {
+	locks := held[id]
+	for i := len(locks) - 1; i >= 0; i-- {
+		if locks[i].lock == lock {
+			locks = append(locks[:i], locks[i+1:]...)
+			if len(locks) == 0 {
+				delete(held, id)
+			} else {
+				held[id] = locks
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// acquired must be called after the lock was acquired.
+func (d *mutexDebug) acquired() {
+	d.lockDebug.acquired()
+	d.hold.start(longHoldConfig())
+	d.site = callSite{}
+	runtime.Callers(1, d.site[:])
+	d.acquiredAt = time.Now()
+	d.record(LockAcquired)
+}
+
+// release must be called when the lock is released.
+func (d *mutexDebug) release() {
+	d.record(LockReleased)
+	// acquiredAt is zero on Unlock of an unlocked mutex, which panics later
+	if !d.acquiredAt.IsZero() {
+		held := time.Since(d.acquiredAt)
+		d.acquiredAt = time.Time{}
+		d.maxMu.Lock()
+		if held > d.maxHold {
+			d.maxHold, d.maxSite = held, d.site
+		}
+		d.maxMu.Unlock()
+	}
+	d.hold.stop()
+	d.lockDebug.release()
+}
+
+// contended must be called after Lock acquired the lock after failing the
+// first attempt. yielded reports whether the processor was yielded meanwhile.
+func (d *mutexDebug) contended(yielded bool) {
+	if yielded {
+		atomic.AddUint64(&d.yieldAcquired, 1)
+	} else {
+		atomic.AddUint64(&d.spinAcquired, 1)
+	}
+}
+
+func (d *mutexDebug) spinYieldRatio() (spinAcquired, yieldAcquired uint64) {
+	return atomic.LoadUint64(&d.spinAcquired), atomic.LoadUint64(&d.yieldAcquired)
+}
+
+func (d *mutexDebug) maxHoldTime() (held time.Duration, file string, line int) {
+	d.maxMu.Lock()
+	held, site := d.maxHold, d.maxSite
+	d.maxMu.Unlock()
+	if held == 0 {
+		return 0, "", 0
+	}
+	file, line = site.caller()
+	return held, file, line
+}
+
+func (d *rwmutexDebug) setReadOwnership() {
+	d.ownedReads = true
+}
+
+// acquiredRead must be called after a read lock was acquired.
+func (d *rwmutexDebug) acquiredRead() {
+	if !d.ownedReads {
+		d.acquired()
+		d.record(RLockAcquired)
+		return
+	}
+	id := goid()
+	d.readersMu.Lock()
+	if d.readers == nil {
+		d.readers = make(map[uint64]int)
+	}
+	d.readers[id]++
+	d.readersMu.Unlock()
+	d.acquired()
+	d.record(RLockAcquired)
+}
+
+// releaseRead must be called when a read lock is released.
+// If read ownership is enabled, it panics if the calling goroutine holds no
+// read lock, which would otherwise silently release the read lock of another
+// goroutine.
+func (d *rwmutexDebug) releaseRead() {
+	if !d.ownedReads {
+		d.record(RLockReleased)
+		d.release()
+		return
+	}
+	id := goid()
+	d.readersMu.Lock()
+	n := d.readers[id]
+	switch n {
+	case 0:
+		d.readersMu.Unlock()
+		panic("spinlock: RUnlock of RWMutex by a goroutine not holding a read lock")
+	case 1:
+		delete(d.readers, id)
+	default:
+		d.readers[id] = n - 1
+	}
+	d.readersMu.Unlock()
+	d.record(RLockReleased)
+	d.release()
+}
+
+// acquireWrite must be called before blocking to acquire the write lock.
+// It panics if the calling goroutine already holds the write lock, which
+// would otherwise deadlock.
+func (d *rwmutexDebug) acquireWrite() {
+	if atomic.LoadUint64(&d.owner) == goid() {
+		panic("spinlock: recursive Lock of RWMutex by the goroutine holding the write lock")
+	}
+	d.acquire()
+}
+
+// acquiredWrite must be called after the write lock was acquired.
+func (d *rwmutexDebug) acquiredWrite() {
+	atomic.StoreUint64(&d.owner, goid())
+	d.acquired()
+	d.hold.start(longHoldConfig())
+	d.record(LockAcquired)
+}
+
+// releaseWrite must be called when the write lock is released.
+func (d *rwmutexDebug) releaseWrite() {
+	d.record(LockReleased)
+	d.hold.stop()
+	atomic.StoreUint64(&d.owner, 0)
+	d.release()
+}
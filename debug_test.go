@@ -0,0 +1,207 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build spinlockdebug
+
+package spinlock
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func expectPanic(t *testing.T, contains string, fn func()) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		r := recover()
+		if r == nil {
+			t.Fatalf("expected panic containing %q", contains)
+		}
+		if msg := fmt.Sprint(r); !strings.Contains(msg, contains) {
+			t.Fatalf("panic %q does not contain %q", msg, contains)
+		}
+	}()
+	fn()
+}
+
+func TestLockLevels(t *testing.T) {
+	var a, b Mutex
+	var rw RWMutex
+	a.SetLevel(1)
+	rw.SetLevel(2)
+	b.SetLevel(3)
+
+	// Increasing levels
+	a.Lock()
+	rw.RLock()
+	b.Lock()
+	b.Unlock()
+	rw.RUnlock()
+	a.Unlock()
+
+	// Unleveled locks are not checked
+	var c Mutex
+	c.Lock()
+	b.Lock()
+	c.Unlock()
+	c.Lock()
+	b.Unlock()
+	c.Unlock()
+
+	// Decreasing levels
+	b.Lock()
+	expectPanic(t, "acquiring lock of level 2 while holding lock of level 3", rw.Lock)
+	b.Unlock()
+
+	// Equal levels
+	var d Mutex
+	d.SetLevel(1)
+	a.Lock()
+	expectPanic(t, "acquiring lock of level 1 while holding lock of level 1", d.Lock)
+	a.Unlock()
+
+	// Locks released by another goroutine are no longer held
+	b.Lock()
+	done := make(chan bool)
+	go func() {
+		b.Unlock()
+		done <- true
+	}()
+	<-done
+	a.Lock()
+	a.Unlock()
+}
+
+func TestRecursiveWriteLock(t *testing.T) {
+	var rw RWMutex
+	rw.Lock()
+	expectPanic(t, "recursive Lock of RWMutex", rw.Lock)
+
+	// Other goroutines are not affected
+	locked := make(chan bool)
+	go func() {
+		rw.Lock()
+		locked <- true
+		rw.Unlock()
+	}()
+	rw.Unlock()
+	<-locked
+
+	// The owner is cleared by Unlock
+	rw.Lock()
+	rw.Unlock()
+	rw.Lock()
+	rw.Unlock()
+}
+
+func TestReadOwnership(t *testing.T) {
+	var rw RWMutex
+	rw.SetReadOwnership()
+
+	// Read locks held by the same goroutine multiple times
+	rw.RLock()
+	if !rw.TryRLock() {
+		t.Fatal("TryRLock failed while read-locked")
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+
+	// Another goroutine holds a read lock
+	locked := make(chan bool)
+	unlock := make(chan bool)
+	go func() {
+		rw.RLock()
+		locked <- true
+		<-unlock
+		rw.RUnlock()
+		locked <- false
+	}()
+	<-locked
+	expectPanic(t, "not holding a read lock", rw.RUnlock)
+	if rw.TryLock() {
+		t.Fatal("read lock of the other goroutine released by unbalanced RUnlock")
+	}
+	unlock <- true
+	<-locked
+
+	// Upgraded read locks are no longer held
+	rw.RLock()
+	if !rw.TryUpgrade() {
+		t.Fatal("TryUpgrade of sole reader failed")
+	}
+	rw.Unlock()
+	expectPanic(t, "not holding a read lock", rw.RUnlock)
+}
+
+func TestSpinYieldRatio(t *testing.T) {
+	defer SetSpinBudget(-1)
+	SetSpinBudget(0)
+
+	var m Mutex
+	m.Lock()
+	m.Unlock()
+	if s, y := m.SpinYieldRatio(); s != 0 || y != 0 {
+		t.Fatalf("uncontended Lock counted: %d spin, %d yield", s, y)
+	}
+
+	// The lock was released before the first spin attempt
+	m.lockSlow()
+	m.Unlock()
+	if s, y := m.SpinYieldRatio(); s != 1 || y != 0 {
+		t.Fatalf("short wait counted as %d spin, %d yield, expected 1, 0", s, y)
+	}
+
+	// The lock is held longer than the spin budget
+	m.Lock()
+	done := make(chan bool)
+	go func() {
+		m.Lock()
+		m.Unlock()
+		done <- true
+	}()
+	time.Sleep(10 * time.Millisecond)
+	m.Unlock()
+	<-done
+	if s, y := m.SpinYieldRatio(); s != 1 || y != 1 {
+		t.Fatalf("long wait counted as %d spin, %d yield, expected 1, 1", s, y)
+	}
+}
+
+func TestMaxHoldTime(t *testing.T) {
+	const long = 20 * time.Millisecond
+
+	var m Mutex
+	if held, file, line := m.MaxHoldTime(); held != 0 || file != "" || line != 0 {
+		t.Fatalf("unused mutex has max hold time %v at %s:%d", held, file, line)
+	}
+
+	m.Lock()
+	time.Sleep(long / 10)
+	m.Unlock()
+
+	_, file, line, _ := runtime.Caller(0)
+	m.Lock() // Acquisition of the longest critical section
+	time.Sleep(long)
+	m.Unlock()
+
+	for _, d := range []time.Duration{0, long / 10, long / 2} {
+		if !m.TryLock() {
+			t.Fatal("TryLock of unlocked mutex failed")
+		}
+		time.Sleep(d)
+		m.Unlock()
+	}
+
+	held, gotFile, gotLine := m.MaxHoldTime()
+	if held < long {
+		t.Fatalf("max hold time %v, expected at least %v", held, long)
+	}
+	if gotFile != file || gotLine != line+1 {
+		t.Fatalf("longest critical section acquired at %s:%d, expected %s:%d", gotFile, gotLine, file, line+1)
+	}
+}
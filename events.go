@@ -0,0 +1,101 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A LockEventKind is the kind of a LockEvent.
+type LockEventKind uint8
+
+const (
+	LockAcquired  LockEventKind = iota + 1 // Mutex or write lock acquired
+	LockReleased                           // Mutex or write lock released
+	RLockAcquired                          // Read lock acquired
+	RLockReleased                          // Read lock released
+)
+
+func (k LockEventKind) String() string {
+	switch k {
+	case LockAcquired:
+		return "LockAcquired"
+	case LockReleased:
+		return "LockReleased"
+	case RLockAcquired:
+		return "RLockAcquired"
+	case RLockReleased:
+		return "RLockReleased"
+	}
+	return "LockEventKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// A LockEvent is a lock operation recorded after EnableLockEvents.
+type LockEvent struct {
+	Kind      LockEventKind
+	Lock      uintptr // Address of the lock, e.g. uintptr(unsafe.Pointer(&m))
+	Goroutine uint64  // ID of the goroutine which performed the operation
+	Time      time.Time
+}
+
+var (
+	lockEvents int32
+
+	lockEventsMu   sync.Mutex
+	lockEventsBuf  []LockEvent // Ring buffer of the recent events
+	lockEventsNext uint64      // Total number of recorded events
+)
+
+// EnableLockEvents starts recording the lock operations of all locks in a ring
+// buffer holding the last size events, which can be retrieved with
+// RecentEvents, e.g. to inspect the recent lock activity of a hanging program.
+// Previously recorded events are discarded. A size <= 0 disables the
+// recording.
+// It only has an effect in debug builds (built with the spinlockdebug tag).
+func EnableLockEvents(size int) {
+	lockEventsMu.Lock()
+	if size > 0 {
+		lockEventsBuf = make([]LockEvent, size)
+		atomic.StoreInt32(&lockEvents, 1)
+	} else {
+		atomic.StoreInt32(&lockEvents, 0)
+		lockEventsBuf = nil
+	}
+	lockEventsNext = 0
+	lockEventsMu.Unlock()
+}
+
+// RecentEvents returns the recorded lock events, oldest first.
+func RecentEvents() []LockEvent {
+	lockEventsMu.Lock()
+	defer lockEventsMu.Unlock()
+	size := uint64(len(lockEventsBuf))
+	start := uint64(0)
+	if lockEventsNext > size {
+		start = lockEventsNext - size
+	}
+	events := make([]LockEvent, 0, lockEventsNext-start)
+	for i := start; i < lockEventsNext; i++ {
+		events = append(events, lockEventsBuf[i%size])
+	}
+	return events
+}
+
+// recordLockEvent records a lock event if the recording is enabled.
+func recordLockEvent(kind LockEventKind, lock uintptr) {
+	if atomic.LoadInt32(&lockEvents) == 0 {
+		return
+	}
+	e := LockEvent{Kind: kind, Lock: lock, Goroutine: goid(), Time: time.Now()}
+	lockEventsMu.Lock()
+	if size := uint64(len(lockEventsBuf)); size > 0 {
+		lockEventsBuf[lockEventsNext%size] = e
+		lockEventsNext++
+	}
+	lockEventsMu.Unlock()
+}
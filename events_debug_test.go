@@ -0,0 +1,128 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build spinlockdebug
+
+package spinlock
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// eventsOf returns the recent events of the lock at addr.
+func eventsOf(addr unsafe.Pointer) []LockEvent {
+	var events []LockEvent
+	for _, e := range RecentEvents() {
+		if e.Lock == uintptr(addr) {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func TestLockEvents(t *testing.T) {
+	var m Mutex
+	var rw RWMutex
+
+	// Disabled by default
+	m.Lock()
+	m.Unlock()
+	if events := eventsOf(unsafe.Pointer(&m)); len(events) != 0 {
+		t.Fatalf("%d events recorded while disabled", len(events))
+	}
+
+	EnableLockEvents(64)
+	defer EnableLockEvents(0)
+	start := time.Now()
+	m.Lock()
+	m.Unlock()
+	rw.RLock()
+	rw.RUnlock()
+	rw.Lock()
+	rw.Unlock()
+	done := make(chan uint64)
+	go func() {
+		m.Lock()
+		m.Unlock()
+		done <- goid()
+	}()
+	other := <-done
+
+	self := goid()
+	for _, lock := range []struct {
+		addr       unsafe.Pointer
+		kinds      []LockEventKind
+		goroutines []uint64
+	}{
+		{
+			unsafe.Pointer(&m),
+			[]LockEventKind{LockAcquired, LockReleased, LockAcquired, LockReleased},
+			[]uint64{self, self, other, other},
+		},
+		{
+			unsafe.Pointer(&rw),
+			[]LockEventKind{RLockAcquired, RLockReleased, LockAcquired, LockReleased},
+			[]uint64{self, self, self, self},
+		},
+	} {
+		events := eventsOf(lock.addr)
+		if len(events) != len(lock.kinds) {
+			t.Fatalf("%d events recorded, expected %d: %v", len(events), len(lock.kinds), events)
+		}
+		prev := start
+		for i, e := range events {
+			if e.Kind != lock.kinds[i] {
+				t.Errorf("event %d is %v, expected %v", i, e.Kind, lock.kinds[i])
+			}
+			if e.Goroutine != lock.goroutines[i] {
+				t.Errorf("event %d by goroutine %d, expected %d", i, e.Goroutine, lock.goroutines[i])
+			}
+			if e.Time.Before(prev) {
+				t.Errorf("event %d at %v before the previous event at %v", i, e.Time, prev)
+			}
+			prev = e.Time
+		}
+	}
+}
+
+func TestLockEventsBounded(t *testing.T) {
+	const size = 4
+
+	EnableLockEvents(size)
+	defer EnableLockEvents(0)
+	var locks [10]Mutex
+	for i := range locks {
+		locks[i].Lock()
+		locks[i].Unlock()
+	}
+	events := RecentEvents()
+	if len(events) != size {
+		t.Fatalf("%d events recorded, expected %d", len(events), size)
+	}
+	// Only the events of the last locks are retained, oldest first
+	for i, e := range events {
+		lock := &locks[len(locks)-size/2+i/2]
+		if e.Lock != uintptr(unsafe.Pointer(lock)) {
+			t.Errorf("event %d of lock %#x, expected %p", i, e.Lock, lock)
+		}
+	}
+
+	EnableLockEvents(0)
+	locks[0].Lock()
+	locks[0].Unlock()
+	if events := RecentEvents(); len(events) != 0 {
+		t.Fatalf("%d events recorded after disabling", len(events))
+	}
+}
+
+func TestLockEventKindString(t *testing.T) {
+	if s := RLockReleased.String(); s != "RLockReleased" {
+		t.Fatalf("RLockReleased.String() = %q", s)
+	}
+	if s := LockEventKind(0).String(); s != "LockEventKind(0)" {
+		t.Fatalf("LockEventKind(0).String() = %q", s)
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync/atomic"
+)
+
+// fenceWord is the location of the atomic operation executed by Fence.
+var fenceWord uint32
+
+// Fence executes an atomic read-modify-write operation on a package-level
+// word. Like all atomic operations in Go it is sequentially consistent: all
+// Fence calls are totally ordered and each call synchronizes with the
+// preceding one, thus everything done before a Fence call happens before
+// everything done after any later Fence call, in the sense of the Go memory
+// model.
+//
+// Go's atomic operations, including those of the locks in this package, are
+// already sequentially consistent, thus Fence is never required to order
+// them. It makes the barrier point explicit in protocols ported from code
+// written against fences. Fence does not make concurrent plain memory accesses
+// safe; those still require atomic operations or locks.
+// It costs about as much as an uncontended Lock, but all callers share its
+// cache line.
+func Fence() {
+	atomic.AddUint32(&fenceWord, 0)
+}
@@ -0,0 +1,69 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// dekker runs both sides of Dekker's store-load protocol concurrently and
+// returns what each side observed of the other's flag. The sequentially
+// consistent atomic flags already order the store before the load; Fence marks
+// the barrier point of the original protocol.
+func dekker() (seenByA, seenByB bool) {
+	var flagA, flagB atomic.Bool
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		flagA.Store(true) // Announce intent
+		Fence()           // Order the store before the load
+		seenByA = flagB.Load()
+	}()
+	go func() {
+		defer wg.Done()
+		flagB.Store(true)
+		Fence()
+		seenByB = flagA.Load()
+	}()
+	wg.Wait()
+	return seenByA, seenByB
+}
+
+func TestFence(t *testing.T) {
+	// Best-effort: at least one side must observe the other, otherwise a
+	// store was reordered after the subsequent load
+	for i := 0; i < 10000; i++ {
+		if a, b := dekker(); !a && !b {
+			t.Fatalf("iteration %d: neither side observed the other", i)
+		}
+	}
+}
+
+func BenchmarkFence(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Fence()
+	}
+}
+
+func BenchmarkFenceParallel(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			Fence()
+		}
+	})
+}
+
+// Dekker's mutual exclusion protocol relies on each side's store of its own
+// flag being visible before it loads the other side's flag. Fence marks that
+// point explicitly: at least one side observes the other and backs off.
+func ExampleFence() {
+	seenByA, seenByB := dekker()
+	fmt.Println(seenByA || seenByB)
+	// Output: true
+}
@@ -0,0 +1,99 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync/atomic"
+)
+
+// A FreezableRWMutex is a reader/writer mutual exclusion lock for data which
+// is written during setup and only read afterwards. Until Freeze is called, it
+// behaves like an RWMutex. Afterwards no writer can ever acquire the lock
+// again, thus RLock and RUnlock return immediately without any synchronization
+// besides a single atomic load.
+// The zero value for a FreezableRWMutex is an unlocked, unfrozen mutex.
+type FreezableRWMutex struct {
+	rw     RWMutex
+	frozen atomic.Bool
+}
+
+// Freeze waits until rw is neither locked for reading nor for writing and
+// freezes it. Locking a frozen FreezableRWMutex for writing panics.
+// Calling Freeze on a frozen FreezableRWMutex is a no-op.
+func (rw *FreezableRWMutex) Freeze() {
+	if rw.frozen.Load() {
+		return
+	}
+	rw.rw.Lock()
+	rw.frozen.Store(true)
+	rw.rw.Unlock()
+}
+
+// Frozen reports whether rw is frozen.
+func (rw *FreezableRWMutex) Frozen() bool {
+	return rw.frozen.Load()
+}
+
+// RLock locks rw for reading.
+// If rw is frozen, RLock returns immediately.
+func (rw *FreezableRWMutex) RLock() {
+	if !rw.frozen.Load() {
+		rw.rw.RLock()
+	}
+}
+
+// TryRLock tries to lock rw for reading.
+// If a lock for reading can not be acquired immediately, false is returned.
+// It always succeeds if rw is frozen.
+func (rw *FreezableRWMutex) TryRLock() bool {
+	return rw.frozen.Load() || rw.rw.TryRLock()
+}
+
+// RUnlock undoes a single RLock call.
+// If rw is frozen, RUnlock returns immediately. Readers are not tracked
+// anymore after Freeze, thus it is not detected if a frozen rw is not locked
+// for reading on entry to RUnlock.
+func (rw *FreezableRWMutex) RUnlock() {
+	if !rw.frozen.Load() {
+		rw.rw.RUnlock()
+	}
+}
+
+// Lock locks rw for writing.
+// It panics if rw is frozen.
+func (rw *FreezableRWMutex) Lock() {
+	if rw.frozen.Load() {
+		panic("spinlock: Lock of frozen FreezableRWMutex")
+	}
+	rw.rw.Lock()
+	if rw.frozen.Load() {
+		// Frozen while waiting for the lock
+		rw.rw.Unlock()
+		panic("spinlock: Lock of frozen FreezableRWMutex")
+	}
+}
+
+// TryLock tries to lock rw for writing.
+// If the lock for writing can not be acquired immediately, false is returned.
+// It panics if rw is frozen.
+func (rw *FreezableRWMutex) TryLock() bool {
+	if rw.frozen.Load() {
+		panic("spinlock: TryLock of frozen FreezableRWMutex")
+	}
+	if !rw.rw.TryLock() {
+		return false
+	}
+	if rw.frozen.Load() {
+		rw.rw.Unlock()
+		panic("spinlock: TryLock of frozen FreezableRWMutex")
+	}
+	return true
+}
+
+// Unlock unlocks rw for writing. It is a run-time error if rw is not locked
+// for writing on entry to Unlock.
+func (rw *FreezableRWMutex) Unlock() {
+	rw.rw.Unlock()
+}
@@ -0,0 +1,88 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFreezableRWMutex(t *testing.T) {
+	const iterations = 1000
+
+	var rw FreezableRWMutex
+	hammerRWLocker(t, &rw, 4, iterations)
+	if rw.Frozen() {
+		t.Fatal("frozen without Freeze")
+	}
+}
+
+func TestFreezableRWMutexFreeze(t *testing.T) {
+	var rw FreezableRWMutex
+	config := map[string]string{}
+
+	// Freeze waits for the writer
+	rw.Lock()
+	frozen := make(chan bool)
+	go func() {
+		rw.Freeze()
+		frozen <- true
+	}()
+	select {
+	case <-frozen:
+		t.Fatal("Freeze did not wait for the writer")
+	case <-time.After(10 * time.Millisecond):
+	}
+	config["mode"] = "frozen"
+	rw.Unlock()
+	<-frozen
+	if !rw.Frozen() {
+		t.Fatal("not frozen after Freeze")
+	}
+	rw.Freeze()
+
+	// Lock-free reads which see the data written before Freeze
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				rw.RLock()
+				if config["mode"] != "frozen" {
+					t.Error("write before Freeze not visible")
+				}
+				rw.RUnlock()
+				if !rw.TryRLock() {
+					t.Error("TryRLock of frozen mutex failed")
+				}
+				rw.RUnlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if state := rw.rw.state; state != rwmutexUnlocked {
+		t.Fatalf("readers of frozen mutex synchronized: state %#x", state)
+	}
+}
+
+func TestFreezableRWMutexWriteAfterFreeze(t *testing.T) {
+	var rw FreezableRWMutex
+	rw.Freeze()
+	for name, fn := range map[string]func(){
+		"Lock":    rw.Lock,
+		"TryLock": func() { rw.TryLock() },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%s of frozen mutex did not panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync/atomic"
+)
+
+// A LazyInit holds a value of type T which is initialized on first use by
+// double-checked locking: once initialized, Get is a single atomic load.
+// The zero value for a LazyInit is uninitialized.
+type LazyInit[T any] struct {
+	done  atomic.Bool
+	mu    Mutex
+	value T
+}
+
+// Get returns the value of l. If l is not initialized yet, it is initialized
+// with the value returned by init. init is called at most once, even by
+// concurrent calls of Get; the other callers wait for it and all callers
+// observe the complete value.
+// If init panics, l remains uninitialized and the next Get calls init again.
+func (l *LazyInit[T]) Get(init func() T) T {
+	// The atomic load orders the read of the value after its publication
+	if l.done.Load() {
+		return l.value
+	}
+	return l.getSlow(init)
+}
+
+func (l *LazyInit[T]) getSlow(init func() T) T {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Re-check, another goroutine may have initialized l meanwhile
+	if !l.done.Load() {
+		l.value = init()
+		l.done.Store(true)
+	}
+	return l.value
+}
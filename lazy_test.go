@@ -0,0 +1,62 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLazyInit(t *testing.T) {
+	type config struct {
+		name  string
+		items []int
+	}
+
+	var l LazyInit[*config]
+	var calls int32
+	init := func() *config {
+		atomic.AddInt32(&calls, 1)
+		return &config{name: "lazy", items: []int{1, 2, 3}}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c := l.Get(init)
+				if c.name != "lazy" || len(c.items) != 3 || c.items[2] != 3 {
+					t.Errorf("incompletely initialized value %+v", c)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("init called %d times, expected once", calls)
+	}
+}
+
+func TestLazyInitPanic(t *testing.T) {
+	var l LazyInit[int]
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic of init not propagated")
+			}
+		}()
+		l.Get(func() int { panic("init") })
+	}()
+	if v := l.Get(func() int { return 42 }); v != 42 {
+		t.Fatalf("Get returned %d after failed init, expected 42", v)
+	}
+	if v := l.Get(func() int { return 0 }); v != 42 {
+		t.Fatalf("Get returned %d after init, expected 42", v)
+	}
+}
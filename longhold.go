@@ -0,0 +1,132 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	longHoldDetection int32
+
+	longHoldMu        sync.Mutex
+	longHoldThreshold time.Duration
+	longHoldFn        func(LongHold)
+)
+
+// A LongHold describes a lock that was held longer than the threshold set with
+// SetLongHoldDetection.
+type LongHold struct {
+	Held  time.Duration // Time the lock was held when it was flagged
+	Stack string        // Stack trace of the goroutine holding the lock
+}
+
+// SetLongHoldDetection enables the detection of locks held longer than
+// threshold. Spinning waiters burn CPU time for as long as the lock is held,
+// thus a lock should never be held across blocking operations such as channel
+// sends and receives, I/O or sleeping. If a Mutex or the write lock of an
+// RWMutex is still held after threshold, fn is called from a separate
+// goroutine with the stack of the goroutine holding the lock, which usually
+// shows the offending blocking operation.
+// A threshold <= 0 or a nil fn disables the detection.
+// It only has an effect in debug builds (built with the spinlockdebug tag).
+func SetLongHoldDetection(threshold time.Duration, fn func(LongHold)) {
+	longHoldMu.Lock()
+	if threshold > 0 && fn != nil {
+		longHoldThreshold, longHoldFn = threshold, fn
+		atomic.StoreInt32(&longHoldDetection, 1)
+	} else {
+		atomic.StoreInt32(&longHoldDetection, 0)
+		longHoldThreshold, longHoldFn = 0, nil
+	}
+	longHoldMu.Unlock()
+}
+
+// longHoldConfig returns the long hold threshold and callback.
+// fn is nil if the detection is disabled.
+func longHoldConfig() (threshold time.Duration, fn func(LongHold)) {
+	if atomic.LoadInt32(&longHoldDetection) == 0 {
+		return 0, nil
+	}
+	longHoldMu.Lock()
+	defer longHoldMu.Unlock()
+	return longHoldThreshold, longHoldFn
+}
+
+// goroutineStack returns the stack trace of the goroutine with the given ID,
+// or an empty string if no such goroutine exists.
+func goroutineStack(id uint64) string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	header := []byte(fmt.Sprintf("goroutine %d [", id))
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(stack, header) {
+			return string(stack)
+		}
+	}
+	return ""
+}
+
+// goid returns the ID of the calling goroutine.
+func goid() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	// The stack trace starts with "goroutine <id> ["
+	var id uint64
+	for _, c := range b[len("goroutine "):] {
+		if c < '0' || c > '9' {
+			break
+		}
+		id = id*10 + uint64(c-'0')
+	}
+	return id
+}
+
+// holdWatch flags an exclusively held lock if it is held longer than a
+// threshold.
+type holdWatch struct {
+	gen   uint64 // Incremented on release, invalidates a pending timer
+	timer *time.Timer
+}
+
+// start must be called after the lock was acquired. If the lock is still held
+// after threshold, fn is called from a separate goroutine. A nil fn disables
+// the watch.
+func (w *holdWatch) start(threshold time.Duration, fn func(LongHold)) {
+	if fn == nil {
+		return
+	}
+	id, start := goid(), time.Now()
+	gen := atomic.LoadUint64(&w.gen)
+	w.timer = time.AfterFunc(threshold, func() {
+		stack := goroutineStack(id)
+		// The lock might have been released while the stack was captured
+		if atomic.LoadUint64(&w.gen) != gen {
+			return
+		}
+		fn(LongHold{Held: time.Since(start), Stack: stack})
+	})
+}
+
+// stop must be called when the lock is released.
+func (w *holdWatch) stop() {
+	atomic.AddUint64(&w.gen, 1)
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build spinlockdebug
+
+package spinlock
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLongHoldDetection(t *testing.T) {
+	const threshold = 20 * time.Millisecond
+
+	var mu sync.Mutex
+	var flagged []LongHold
+	SetLongHoldDetection(threshold, func(h LongHold) {
+		mu.Lock()
+		flagged = append(flagged, h)
+		mu.Unlock()
+	})
+	defer SetLongHoldDetection(0, nil)
+	flaggedHolds := func() []LongHold {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]LongHold(nil), flagged...)
+	}
+
+	var m Mutex
+	var rw RWMutex
+	for _, lock := range []struct {
+		name         string
+		lock, unlock func()
+	}{
+		{"Mutex", m.Lock, m.Unlock},
+		{"RWMutex", rw.Lock, rw.Unlock},
+	} {
+		mu.Lock()
+		flagged = nil
+		mu.Unlock()
+
+		// Short critical sections must not be flagged
+		lock.lock()
+		lock.unlock()
+		time.Sleep(2 * threshold)
+		if h := flaggedHolds(); len(h) != 0 {
+			t.Fatalf("%s: short hold flagged: %v", lock.name, h)
+		}
+
+		lock.lock()
+		time.Sleep(5 * threshold)
+		lock.unlock()
+		h := flaggedHolds()
+		if len(h) != 1 {
+			t.Fatalf("%s: long hold flagged %d times, expected once", lock.name, len(h))
+		}
+		if h[0].Held < threshold {
+			t.Errorf("%s: flagged hold duration %v, expected at least %v", lock.name, h[0].Held, threshold)
+		}
+		if !strings.Contains(h[0].Stack, "time.Sleep") {
+			t.Errorf("%s: holder stack does not show the blocking operation:\n%s", lock.name, h[0].Stack)
+		}
+	}
+
+	// Read locks are not watched
+	rw.RLock()
+	time.Sleep(2 * threshold)
+	rw.RUnlock()
+	if h := flaggedHolds(); len(h) != 1 {
+		t.Fatalf("read lock flagged: %v", h)
+	}
+}
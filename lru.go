@@ -0,0 +1,80 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"container/list"
+)
+
+// An LRU is a fixed-capacity cache safe for concurrent use, which evicts the
+// least recently used entry when it is full.
+//
+// All operations are guarded by a single Mutex. A read lock does not suffice
+// for Get, since a hit moves the entry to the front of the eviction list.
+// The critical sections are short, so a spinlock fits well; under very high
+// concurrency, shard the keys over multiple LRUs.
+// An LRU must be created with NewLRU.
+type LRU[K comparable, V any] struct {
+	mu       Mutex
+	capacity int
+	items    map[K]*list.Element
+	order    list.List // Front is most recently used
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// NewLRU returns an empty LRU holding at most capacity entries.
+// It panics if capacity is not positive.
+func NewLRU[K comparable, V any](capacity int) *LRU[K, V] {
+	if capacity <= 0 {
+		panic("spinlock: non-positive LRU capacity")
+	}
+	return &LRU[K, V]{
+		capacity: capacity,
+		items:    make(map[K]*list.Element, capacity),
+	}
+}
+
+// Get returns the value cached for key and marks it as most recently used.
+// If key is not cached, the zero value of V and false are returned.
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry[K, V]).value, true
+}
+
+// Put caches value for key and marks it as most recently used. If c is full,
+// the least recently used entry is evicted.
+func (c *LRU[K, V]) Put(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.Value.(*lruEntry[K, V]).value = value
+		c.order.MoveToFront(e)
+		return
+	}
+	if c.order.Len() >= c.capacity {
+		oldest := c.order.Back()
+		delete(c.items, oldest.Value.(*lruEntry[K, V]).key)
+		c.order.Remove(oldest)
+	}
+	c.items[key] = c.order.PushFront(&lruEntry[K, V]{key: key, value: value})
+}
+
+// Len returns the number of cached entries.
+func (c *LRU[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,94 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLRU(t *testing.T) {
+	c := NewLRU[string, int](2)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("Get of empty cache hit")
+	}
+	c.Put("a", 1)
+	c.Put("b", 2)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %d, %v, expected 1, true", v, ok)
+	}
+
+	// b is the least recently used entry
+	c.Put("c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("least recently used entry not evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %d, %v after eviction, expected 1, true", v, ok)
+	}
+	if n := c.Len(); n != 2 {
+		t.Fatalf("Len() = %d, expected 2", n)
+	}
+
+	// Updates mark the entry as used; c is evicted next
+	c.Put("a", 10)
+	c.Put("d", 4)
+	if _, ok := c.Get("c"); ok {
+		t.Fatal("least recently used entry not evicted after update")
+	}
+	if v, _ := c.Get("a"); v != 10 {
+		t.Fatalf("Get(a) = %d after update, expected 10", v)
+	}
+}
+
+func TestLRUPanic(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("non-positive capacity did not panic")
+		}
+	}()
+	NewLRU[int, int](0)
+}
+
+func TestLRUConcurrent(t *testing.T) {
+	const capacity = 64
+
+	c := NewLRU[int, int](capacity)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := (g*1000 + j) % (2 * capacity)
+				if v, ok := c.Get(key); ok && v != key {
+					t.Errorf("Get(%d) = %d", key, v)
+				}
+				c.Put(key, key)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := c.Len(); n != capacity {
+		t.Fatalf("Len() = %d, expected %d", n, capacity)
+	}
+}
+
+func BenchmarkLRU(b *testing.B) {
+	const capacity = 1024
+
+	c := NewLRU[int, int](capacity)
+	for i := 0; i < capacity; i++ {
+		c.Put(i, i)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			key := i % (2 * capacity)
+			if _, ok := c.Get(key); !ok {
+				c.Put(key, key)
+			}
+		}
+	})
+}
@@ -0,0 +1,86 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+	"weak"
+)
+
+// Metadata of mutexes is kept in a side table, so that it adds no overhead to
+// mutexes without metadata. The table is keyed by the address of the mutex, so
+// that looking up the metadata does not make the mutex escape to the heap.
+// The weak pointer tells a mutex apart from a later one at the same address.
+// Entries are removed when the mutex is garbage collected.
+var (
+	metadataMu Mutex
+	metadata   map[uintptr]metadataEntry
+)
+
+type metadataEntry struct {
+	m weak.Pointer[Mutex]
+	v any
+}
+
+// SetMetadata attaches arbitrary metadata to m for tooling, such as the name
+// of the owning subsystem or a documentation link. The metadata is included
+// in panic messages of m. A nil v removes the metadata.
+func (m *Mutex) SetMetadata(v any) {
+	key, wp := uintptr(unsafe.Pointer(m)), weak.Make(m)
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	e, ok := metadata[key]
+	ok = ok && e.m == wp
+	if v == nil {
+		if ok {
+			delete(metadata, key)
+		}
+		return
+	}
+	if metadata == nil {
+		metadata = make(map[uintptr]metadataEntry)
+	}
+	if !ok {
+		runtime.AddCleanup(m, deleteMetadata, metadataRef{key: key, m: wp})
+	}
+	metadata[key] = metadataEntry{m: wp, v: v}
+}
+
+// A metadataRef identifies the metadata of a collected mutex.
+type metadataRef struct {
+	key uintptr
+	m   weak.Pointer[Mutex]
+}
+
+// deleteMetadata removes the metadata of the collected mutex, unless the
+// address was already reused by another mutex with metadata.
+func deleteMetadata(ref metadataRef) {
+	metadataMu.Lock()
+	if metadata[ref.key].m == ref.m {
+		delete(metadata, ref.key)
+	}
+	metadataMu.Unlock()
+}
+
+// Metadata returns the metadata attached to m with SetMetadata, or nil.
+func (m *Mutex) Metadata() any {
+	key := uintptr(unsafe.Pointer(m))
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	if e, ok := metadata[key]; ok && e.m.Value() == m {
+		return e.v
+	}
+	return nil
+}
+
+// errorf formats a panic message for m, including its metadata.
+func (m *Mutex) errorf(msg string) string {
+	if v := m.Metadata(); v != nil {
+		return fmt.Sprintf("%s (%v)", msg, v)
+	}
+	return msg
+}
@@ -0,0 +1,105 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMutexMetadata(t *testing.T) {
+	type owner struct {
+		Subsystem string
+		Doc       string
+	}
+
+	var m, other Mutex
+	if v := m.Metadata(); v != nil {
+		t.Fatalf("unset metadata is %v, expected nil", v)
+	}
+	m.SetMetadata(owner{"storage", "https://example.com/locks"})
+	if v, ok := m.Metadata().(owner); !ok || v.Subsystem != "storage" {
+		t.Fatalf("metadata is %v, expected the set owner", m.Metadata())
+	}
+	if v := other.Metadata(); v != nil {
+		t.Fatalf("metadata of another mutex is %v, expected nil", v)
+	}
+
+	// The metadata is included in panic messages
+	func() {
+		defer func() {
+			if msg := fmt.Sprint(recover()); !strings.Contains(msg, "storage") {
+				t.Fatalf("panic %q does not contain the metadata", msg)
+			}
+		}()
+		m.Unlock()
+	}()
+
+	m.SetMetadata(nil)
+	if v := m.Metadata(); v != nil {
+		t.Fatalf("metadata is %v after removal, expected nil", v)
+	}
+}
+
+func TestMutexMetadataCleanup(t *testing.T) {
+	m := new(Mutex)
+	m.SetMetadata("garbage")
+	m = nil
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		runtime.GC()
+		metadataMu.Lock()
+		n := len(metadata)
+		metadataMu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("metadata of collected mutex not removed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMutexMetadataNoAllocs(t *testing.T) {
+	var m Mutex
+	if allocs := testing.AllocsPerRun(100, func() {
+		m.Lock()
+		m.Unlock()
+	}); allocs != 0 {
+		t.Fatalf("Lock and Unlock of mutex without metadata allocated %v times", allocs)
+	}
+
+	// A mutex which does not escape is not moved to the heap by the metadata
+	// lookup of the panic paths. The debug hooks keep track of locks, thus
+	// this does not hold in debug builds.
+	if allocs := testing.AllocsPerRun(100, func() {
+		var mu Mutex
+		mu.Lock()
+		mu.Unlock()
+		if mu.TryLock() {
+			mu.Unlock()
+		}
+		mu.MustLock()
+		mu.Unlock()
+	}); allocs != 0 && !debug {
+		t.Fatalf("Lock and Unlock of a local mutex allocated %v times", allocs)
+	}
+
+	// Setting metadata on one mutex does not affect others
+	var other Mutex
+	other.SetMetadata("other")
+	defer other.SetMetadata(nil)
+	if allocs := testing.AllocsPerRun(100, func() {
+		m.Lock()
+		m.Unlock()
+	}); allocs != 0 {
+		t.Fatalf("Lock and Unlock of mutex without metadata allocated %v times", allocs)
+	}
+}
@@ -5,8 +5,10 @@
 package spinlock
 
 import (
-	"runtime"
+	"math"
+	"math/rand/v2"
 	"sync/atomic"
+	"time"
 )
 
 const (
@@ -17,24 +19,194 @@ const (
 // A Mutex is a mutual exclusion lock.
 // Mutexes can be created as part of other structures;
 // the zero value for a Mutex is an unlocked mutex.
+// A Mutex implements sync.Locker and can be used as the Locker of a sync.Cond.
+// Goroutines waiting on the Cond are parked; only reacquiring the lock after a
+// wakeup busy waits.
 type Mutex struct {
+	dbg   mutexDebug
 	state int32
 }
 
 // Lock locks m.
 // If the lock is already in use, the calling goroutine repetitively tries to
 // acquire the lock until it is available (busy waiting).
+// After SpinBudget failed attempts, or fewer in SMT mode (see SetSMTMode), the
+// processor is yielded.
 func (m *Mutex) Lock() {
+	m.dbg.acquire()
+	if !atomic.CompareAndSwapInt32(&m.state, mutexUnlocked, mutexLocked) {
+		m.lockSlow()
+	}
+	m.dbg.acquired()
+}
+
+func (m *Mutex) lockSlow() {
+	recordContention()
+	yielded := false
+	for !m.spinTryLock(spinAttempts()) {
+		yield()
+		yielded = true
+	}
+	m.dbg.contended(yielded)
+}
+
+// spinTryLock tries to lock m, retrying up to budget times while busy waiting.
+func (m *Mutex) spinTryLock(budget int) bool {
+	if atomic.CompareAndSwapInt32(&m.state, mutexUnlocked, mutexLocked) {
+		return true
+	}
+	for i := budget; i > 0; i-- {
+		if atomic.LoadInt32(&m.state) == mutexUnlocked &&
+			atomic.CompareAndSwapInt32(&m.state, mutexUnlocked, mutexLocked) {
+			return true
+		}
+		spinPause()
+	}
+	return false
+}
+
+// A Priority is the priority of a lock acquisition.
+type Priority int
+
+const (
+	// LowPriority acquisitions yield the processor after each failed attempt
+	// to acquire the lock, conserving CPU time.
+	LowPriority Priority = iota
+
+	// HighPriority acquisitions spin more aggressively than Lock to minimize
+	// the acquisition latency.
+	HighPriority
+)
+
+// highPrioritySpinFactor is the factor by which HighPriority acquisitions
+// extend the spin budget.
+const highPrioritySpinFactor = 4
+
+// LockPriority locks m like Lock, but trades acquisition latency against CPU
+// usage according to the priority p.
+func (m *Mutex) LockPriority(p Priority) {
+	budget := 0
+	if p == HighPriority {
+		budget = highPrioritySpinFactor * spinAttempts()
+	}
+	m.dbg.acquire()
+	for !m.spinTryLock(budget) {
+		yield()
+	}
+	m.dbg.acquired()
+}
+
+// LockBackoff locks m like Lock, but waits between failed attempts to acquire
+// the lock instead of spinning.
+// The waiting time is chosen randomly from [0, d), where d starts at base and
+// doubles after each failed attempt until it reaches max (full jitter
+// exponential backoff). Under high contention this desynchronizes the waiting
+// goroutines and saves CPU time.
+// It panics if base is not positive or max is less than base.
+func (m *Mutex) LockBackoff(base, max time.Duration) {
+	if base <= 0 || max < base {
+		panic("spinlock: invalid backoff durations")
+	}
+	m.dbg.acquire()
+	for d := base; !atomic.CompareAndSwapInt32(&m.state, mutexUnlocked, mutexLocked); {
+		time.Sleep(rand.N(d))
+		if d < max {
+			if d *= 2; d > max {
+				d = max
+			}
+		}
+	}
+	m.dbg.acquired()
+}
+
+// LockRelax locks m like Lock, but executes pauseCount CPU pause instructions
+// after each failed attempt to acquire the lock instead of yielding the
+// processor. A pauseCount of 0 is a tight spin.
+// LockRelax never yields the processor voluntarily. It is meant for expert
+// tuning where the length of the critical section is known precisely and
+// the lock holder is guaranteed to run in parallel.
+// It panics if pauseCount is negative.
+func (m *Mutex) LockRelax(pauseCount int) {
+	if pauseCount < 0 {
+		panic("spinlock: negative LockRelax pause count")
+	}
+	m.dbg.acquire()
 	for !atomic.CompareAndSwapInt32(&m.state, mutexUnlocked, mutexLocked) {
-		runtime.Gosched()
+		if pauseCount > 0 {
+			procyield(uint32(pauseCount))
+		}
 	}
+	m.dbg.acquired()
+}
+
+// A TryLockStrategy determines how TryLock attempts to acquire a lock.
+type TryLockStrategy int32
+
+const (
+	// TestAndTest loads the lock state first and only attempts the
+	// compare-and-swap if the lock appears to be free (test-and-test-and-set).
+	// Failing attempts on a contended lock then do not write to its cache
+	// line. This is the default.
+	TestAndTest TryLockStrategy = iota
+
+	// StrongCAS always attempts a single compare-and-swap, which acquires the
+	// cache line of the lock exclusively even if the lock is in use.
+	StrongCAS
+)
+
+// tryLockStrategy is the TryLockStrategy used by TryLock.
+var tryLockStrategy int32
+
+// SetTryLockStrategy sets the strategy used by the TryLock methods of Mutex and
+// RWMutex. Both strategies never block; they only differ in their effect on
+// the memory contention of a contended lock.
+func SetTryLockStrategy(strategy TryLockStrategy) {
+	atomic.StoreInt32(&tryLockStrategy, int32(strategy))
+}
+
+// testBeforeTryLock reports whether TryLock loads the lock state before
+// attempting the compare-and-swap.
+func testBeforeTryLock() bool {
+	return atomic.LoadInt32(&tryLockStrategy) == int32(TestAndTest)
 }
 
 // TryLock tries to lock m.
 // If the lock is already in use, the lock is not acquired and false is
 // returned.
 func (m *Mutex) TryLock() bool {
-	return atomic.CompareAndSwapInt32(&m.state, mutexUnlocked, mutexLocked)
+	if testBeforeTryLock() && atomic.LoadInt32(&m.state) != mutexUnlocked ||
+		!atomic.CompareAndSwapInt32(&m.state, mutexUnlocked, mutexLocked) {
+		return false
+	}
+	m.dbg.acquired()
+	return true
+}
+
+// LockOrElse tries to lock m once, like TryLock. If the lock is already in use,
+// fallback is called instead of waiting for the lock, e.g. to defer the work or
+// to count it as dropped. It reports whether the lock was acquired, in which
+// case the caller must unlock m:
+//
+//	if m.LockOrElse(func() { dropped.Add(1) }) {
+//		defer m.Unlock()
+//		...
+//	}
+func (m *Mutex) LockOrElse(fallback func()) bool {
+	if m.TryLock() {
+		return true
+	}
+	fallback()
+	return false
+}
+
+// MustLock locks m, asserting that m is not in use.
+// Unlike Lock, which would wait for the lock, it panics if m is already
+// locked. MustLock makes the invariant "this lock is uncontended here"
+// explicit at the call site.
+func (m *Mutex) MustLock() {
+	if !m.TryLock() {
+		panic(m.errorf("spinlock: MustLock of locked mutex"))
+	}
 }
 
 // Unlock unlocks m.
@@ -44,8 +216,131 @@ func (m *Mutex) TryLock() bool {
 // It is allowed for one goroutine to lock a Mutex and then
 // arrange for another goroutine to unlock it.
 func (m *Mutex) Unlock() {
-	state := atomic.AddInt32(&m.state, -mutexLocked)
-	if state != mutexUnlocked {
-		panic("spinlock: unlock of unlocked mutex")
+	m.dbg.release()
+	if atomic.AddInt32(&m.state, -mutexLocked) != mutexUnlocked ||
+		atomic.LoadUint64(&bargingThreshold) != 0 {
+		m.unlockSlow()
+	}
+}
+
+// unlockSlow handles the rare cases of Unlock out of line, keeping Unlock
+// inlinable.
+//
+//go:noinline
+func (m *Mutex) unlockSlow() {
+	// A negative state only results from Unlock of an unlocked m and stays
+	// negative, as the lock can not be acquired anymore
+	if atomic.LoadInt32(&m.state) < mutexUnlocked {
+		m.unlockPanic()
+	}
+
+	// Give waiting goroutines a chance to acquire the lock before the
+	// releasing goroutine can barge in again
+	suppressBarging()
+}
+
+// unlockPanic panics on Unlock of an unlocked m.
+//
+//go:noinline
+func (m *Mutex) unlockPanic() {
+	panic(m.errorf("spinlock: unlock of unlocked mutex"))
+}
+
+// suppressBarging yields the processor with the barging suppression
+// probability.
+func suppressBarging() {
+	if bargingYield(rand.Uint64()) {
+		yield()
+	}
+}
+
+// bargingYield reports whether Unlock yields for the random number r.
+func bargingYield(r uint64) bool {
+	return r < atomic.LoadUint64(&bargingThreshold)
+}
+
+// SetLevel assigns m a level for detecting lock order violations.
+// Leveled locks must be acquired in strictly increasing order of their levels.
+// Acquiring a leveled lock while holding a leveled lock of a higher or equal
+// level panics, preventing deadlocks by construction.
+// SetLevel must be called before m is used. It only has an effect in debug
+// builds (built with the spinlockdebug tag).
+func (m *Mutex) SetLevel(level int) {
+	m.dbg.setLevel(level)
+}
+
+// SpinYieldRatio returns the number of contended Lock acquisitions of m that
+// succeeded while spinning and after yielding the processor. If most
+// acquisitions succeed only after yielding, the spin budget is wasted.
+// The acquisitions are only counted in debug builds (built with the
+// spinlockdebug tag).
+func (m *Mutex) SpinYieldRatio() (spinAcquired, yieldAcquired uint64) {
+	return m.dbg.spinYieldRatio()
+}
+
+// MaxHoldTime returns the duration of the longest critical section of m so far
+// and the position of the call that acquired the lock for it. A single long
+// critical section can cause a latency spike that averages hide.
+// It only records critical sections in debug builds (built with the
+// spinlockdebug tag); otherwise it returns 0, "" and 0.
+func (m *Mutex) MaxHoldTime() (held time.Duration, file string, line int) {
+	return m.dbg.maxHoldTime()
+}
+
+// bargingThreshold is the barging suppression probability scaled to the range
+// of uint64. 0 disables barging suppression.
+var bargingThreshold uint64
+
+// SetBargingSuppression sets the probability with which Mutex.Unlock yields
+// the processor after releasing the lock, before the releasing goroutine can
+// acquire the lock again.
+// This improves the chance of long-waiting goroutines to acquire the lock and
+// thereby the fairness of the lock statistically, but gives no guarantee.
+// A probability of 0, the default, disables barging suppression.
+// It panics if probability is not in the range [0, 1].
+func SetBargingSuppression(probability float64) {
+	var threshold uint64
+	switch {
+	case !(probability >= 0 && probability <= 1):
+		panic("spinlock: barging suppression probability out of range")
+	case probability == 1:
+		threshold = math.MaxUint64
+	default:
+		threshold = uint64(probability * (1 << 64))
+	}
+	atomic.StoreUint64(&bargingThreshold, threshold)
+}
+
+// Acquire tries to lock m, like TryLock, and returns the outcome as an
+// Acquisition. The Acquisition can be unlocked unconditionally:
+//
+//	a := m.Acquire()
+//	defer a.Unlock()
+//	if a.Held() {
+//		...
+//	}
+func (m *Mutex) Acquire() Acquisition {
+	if m.TryLock() {
+		return Acquisition{m: m}
+	}
+	return Acquisition{}
+}
+
+// An Acquisition is the result of Mutex.Acquire.
+type Acquisition struct {
+	m *Mutex
+}
+
+// Held reports whether the lock was acquired and is not yet released by a.
+func (a *Acquisition) Held() bool {
+	return a.m != nil
+}
+
+// Unlock unlocks the mutex if it was acquired.
+// It is a no-op if the lock was not acquired or Unlock was already called.
+func (a *Acquisition) Unlock() {
+	if m := a.m; m != nil {
+		a.m = nil
+		m.Unlock()
 	}
 }
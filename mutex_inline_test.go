@@ -0,0 +1,56 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The race detector instrumentation and the debug hooks prevent inlining.
+
+//go:build !race && !spinlockdebug
+
+package spinlock
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// panicControl is trivially inlinable.
+func panicControl() {
+	panic("control")
+}
+
+// inlinedOnPanic calls f, which must panic, and reports whether the function
+// with the given name suffix is on the stack of the panic and was inlined.
+func inlinedOnPanic(name string, f func()) (inlined bool) {
+	defer func() {
+		recover()
+		pcs := make([]uintptr, 64)
+		frames := runtime.CallersFrames(pcs[:runtime.Callers(0, pcs)])
+		for {
+			frame, more := frames.Next()
+			if strings.HasSuffix(frame.Function, name) {
+				inlined = frame.Func == nil
+				return
+			}
+			if !more {
+				return
+			}
+		}
+	}()
+	f()
+	return false
+}
+
+// The uncontended fast path is only close to the two-atomic floor if Unlock
+// is inlined, see BenchmarkMutexCounter.
+func TestMutexUnlockInlined(t *testing.T) {
+	if !inlinedOnPanic(".panicControl", func() { panicControl() }) {
+		t.Skip("inlining is disabled")
+	}
+	if !inlinedOnPanic(".(*Mutex).Unlock", func() {
+		var m Mutex
+		m.Unlock()
+	}) {
+		t.Fatal("Mutex.Unlock is not inlined")
+	}
+}
@@ -6,7 +6,13 @@
 package spinlock
 
 import (
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func HammerMutex(m *Mutex, loops int, cdone chan bool) {
@@ -17,6 +23,38 @@ func HammerMutex(m *Mutex, loops int, cdone chan bool) {
 	cdone <- true
 }
 
+// funcLocker is a sync.Locker calling lock and unlock.
+type funcLocker struct {
+	lock, unlock func()
+}
+
+func (l funcLocker) Lock()   { l.lock() }
+func (l funcLocker) Unlock() { l.unlock() }
+
+// hammerLocker runs a goroutine for each of the lockers, which must all lock
+// the same lock, locking it iterations times and checking that no other
+// goroutine is in the critical section.
+func hammerLocker(t *testing.T, iterations int, lockers ...sync.Locker) {
+	t.Helper()
+	var activity int32
+	var wg sync.WaitGroup
+	for _, l := range lockers {
+		wg.Add(1)
+		go func(l sync.Locker) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				l.Lock()
+				if n := atomic.AddInt32(&activity, 1); n != 1 {
+					t.Errorf("%d goroutines in critical section", n)
+				}
+				atomic.AddInt32(&activity, -1)
+				l.Unlock()
+			}
+		}(l)
+	}
+	wg.Wait()
+}
+
 func TestMutex(t *testing.T) {
 	m := new(Mutex)
 	c := make(chan bool)
@@ -56,6 +94,143 @@ func TestMutexTry(t *testing.T) {
 	}
 }
 
+func TestMutexLockOrElse(t *testing.T) {
+	var m Mutex
+	fallbacks := 0
+	fallback := func() { fallbacks++ }
+
+	if !m.LockOrElse(fallback) {
+		t.Fatal("LockOrElse of unlocked mutex failed")
+	}
+	if fallbacks != 0 {
+		t.Fatal("fallback called although the lock was acquired")
+	}
+	if m.LockOrElse(fallback) {
+		t.Fatal("LockOrElse of locked mutex succeeded")
+	}
+	if fallbacks != 1 {
+		t.Fatalf("fallback called %d times on contention, expected once", fallbacks)
+	}
+	m.Unlock()
+	if !m.TryLock() {
+		t.Fatal("lock held after contended LockOrElse")
+	}
+	m.Unlock()
+}
+
+func TestMutexCond(t *testing.T) {
+	const items = 1000
+
+	var m Mutex
+	cond := sync.NewCond(&m)
+	var queue []int
+	done := make(chan int)
+	for i := 0; i < 4; i++ {
+		go func() {
+			sum := 0
+			for {
+				m.Lock()
+				for len(queue) == 0 {
+					cond.Wait()
+				}
+				v := queue[0]
+				queue = queue[1:]
+				m.Unlock()
+				if v < 0 {
+					done <- sum
+					return
+				}
+				sum += v
+			}
+		}()
+	}
+
+	for i := 1; i <= items; i++ {
+		m.Lock()
+		queue = append(queue, i)
+		m.Unlock()
+		cond.Signal()
+	}
+	m.Lock()
+	queue = append(queue, -1, -1, -1, -1)
+	m.Unlock()
+	cond.Broadcast()
+
+	sum := 0
+	for i := 0; i < 4; i++ {
+		sum += <-done
+	}
+	if sum != items*(items+1)/2 {
+		t.Fatalf("consumed sum %d, expected %d", sum, items*(items+1)/2)
+	}
+}
+
+func TestTryLockStrategy(t *testing.T) {
+	defer SetTryLockStrategy(TestAndTest)
+	for _, strategy := range []TryLockStrategy{StrongCAS, TestAndTest} {
+		SetTryLockStrategy(strategy)
+
+		var m Mutex
+		var rw RWMutex
+		if !m.TryLock() || !rw.TryLock() {
+			t.Fatalf("strategy %d: TryLock of unlocked lock failed", strategy)
+		}
+		if m.TryLock() || rw.TryLock() {
+			t.Fatalf("strategy %d: TryLock of locked lock succeeded", strategy)
+		}
+		m.Unlock()
+		rw.Unlock()
+		rw.RLock()
+		if rw.TryLock() {
+			t.Fatalf("strategy %d: TryLock of read-locked RWMutex succeeded", strategy)
+		}
+		rw.RUnlock()
+
+		// Exactly one of many concurrent attempts succeeds, none blocks
+		var acquired int32
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if m.TryLock() {
+					atomic.AddInt32(&acquired, 1)
+				}
+			}()
+		}
+		wg.Wait()
+		if acquired != 1 {
+			t.Fatalf("strategy %d: %d concurrent TryLocks succeeded, expected 1", strategy, acquired)
+		}
+	}
+}
+
+func TestMutexMustLock(t *testing.T) {
+	var m Mutex
+	m.MustLock()
+	if m.TryLock() {
+		t.Fatal("TryLock succeeded after MustLock")
+	}
+
+	func() {
+		defer func() {
+			r := recover()
+			if r == nil {
+				t.Fatal("MustLock of locked mutex did not panic")
+			}
+			if r != "spinlock: MustLock of locked mutex" {
+				t.Fatalf("unexpected panic: %v", r)
+			}
+		}()
+		m.MustLock()
+	}()
+
+	// The failed MustLock must not have affected the lock
+	m.Unlock()
+	m.MustLock()
+	m.Unlock()
+}
+
 func TestMutexPanic(t *testing.T) {
 	defer func() {
 		if recover() == nil {
@@ -69,6 +244,86 @@ func TestMutexPanic(t *testing.T) {
 	mu.Unlock()
 }
 
+func TestMutexLockBackoff(t *testing.T) {
+	const goroutines = 10
+	const iterations = 100
+
+	var m Mutex
+	lockers := make([]sync.Locker, goroutines)
+	for i := range lockers {
+		lockers[i] = funcLocker{func() { m.LockBackoff(time.Microsecond, time.Millisecond) }, m.Unlock}
+	}
+	hammerLocker(t, iterations, lockers...)
+}
+
+func TestMutexLockBackoffPanic(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("invalid backoff durations did not panic")
+		}
+	}()
+	var m Mutex
+	m.LockBackoff(time.Millisecond, time.Microsecond)
+}
+
+func TestMutexLockPriority(t *testing.T) {
+	const iterations = 1000
+
+	var m Mutex
+	lockers := make([]sync.Locker, 6)
+	for i := range lockers {
+		p := Priority(i % 2)
+		lockers[i] = funcLocker{func() { m.LockPriority(p) }, m.Unlock}
+	}
+	hammerLocker(t, iterations, lockers...)
+}
+
+func TestMutexLockRelax(t *testing.T) {
+	const iterations = 1000
+
+	var m Mutex
+	lockers := make([]sync.Locker, 4)
+	for i := range lockers {
+		pauseCount := i * 10
+		lockers[i] = funcLocker{func() { m.LockRelax(pauseCount) }, m.Unlock}
+	}
+	hammerLocker(t, iterations, lockers...)
+}
+
+func TestMutexLockRelaxPanic(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("negative pause count did not panic")
+		}
+	}()
+	var m Mutex
+	m.LockRelax(-1)
+}
+
+func benchmarkMutexTryLockContended(b *testing.B, strategy TryLockStrategy) {
+	defer SetTryLockStrategy(TestAndTest)
+	SetTryLockStrategy(strategy)
+
+	var m Mutex
+	m.Lock()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if m.TryLock() {
+				b.Fatal("TryLock of locked mutex succeeded")
+			}
+		}
+	})
+	m.Unlock()
+}
+
+func BenchmarkMutexTryLockContendedStrongCAS(b *testing.B) {
+	benchmarkMutexTryLockContended(b, StrongCAS)
+}
+
+func BenchmarkMutexTryLockContendedTestAndTest(b *testing.B) {
+	benchmarkMutexTryLockContended(b, TestAndTest)
+}
+
 func BenchmarkMutexUncontended(b *testing.B) {
 	type PaddedMutex struct {
 		Mutex
@@ -174,3 +429,208 @@ func BenchmarkMutexSpin(b *testing.B) {
 		}
 	})
 }
+
+func TestMutexAcquire(t *testing.T) {
+	var m Mutex
+	a := m.Acquire()
+	if !a.Held() {
+		t.Fatal("Acquire failed")
+	}
+	if m.TryLock() {
+		t.Fatal("TryLock succeeded while acquired")
+	}
+
+	b := m.Acquire()
+	if b.Held() {
+		t.Fatal("Acquire succeeded while locked")
+	}
+	b.Unlock() // must be a no-op
+	if m.TryLock() {
+		t.Fatal("Unlock of a not held Acquisition released the lock")
+	}
+
+	a.Unlock()
+	if a.Held() {
+		t.Fatal("Acquisition still held after Unlock")
+	}
+	a.Unlock() // must not release twice (would panic)
+
+	if !m.TryLock() {
+		t.Fatal("TryLock failed after Unlock")
+	}
+	m.Unlock()
+}
+
+func TestMutexBargingSuppression(t *testing.T) {
+	defer SetBargingSuppression(0)
+	tests := []struct {
+		probability float64
+		yield       []uint64
+		noYield     []uint64
+	}{
+		{0, nil, []uint64{0, 1 << 63, math.MaxUint64}},
+		{0.25, []uint64{0, 1<<62 - 1}, []uint64{1 << 62, math.MaxUint64}},
+		{0.5, []uint64{0, 1<<63 - 1}, []uint64{1 << 63, math.MaxUint64}},
+		{1, []uint64{0, 1 << 63, math.MaxUint64 - 1}, nil},
+	}
+	for _, tt := range tests {
+		SetBargingSuppression(tt.probability)
+		for _, r := range tt.yield {
+			if !bargingYield(r) {
+				t.Errorf("probability %v: no yield for %#x", tt.probability, r)
+			}
+		}
+		for _, r := range tt.noYield {
+			if bargingYield(r) {
+				t.Errorf("probability %v: yield for %#x", tt.probability, r)
+			}
+		}
+	}
+
+	// Unlock takes the suppression path and still releases the lock
+	SetBargingSuppression(1)
+	var m Mutex
+	m.Lock()
+	m.Unlock()
+	if !m.TryLock() {
+		t.Fatal("TryLock failed after Unlock with barging suppression")
+	}
+	m.Unlock()
+}
+
+func TestSetBargingSuppressionPanic(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("out of range probability did not panic")
+		}
+	}()
+	SetBargingSuppression(1.5)
+}
+
+func benchmarkMutexHighContention(b *testing.B, lock func(m *Mutex)) {
+	var m Mutex
+	b.SetParallelism(64)
+	b.RunParallel(func(pb *testing.PB) {
+		foo := 0
+		for pb.Next() {
+			lock(&m)
+			for i := 0; i < 1000; i++ {
+				foo *= 2
+				foo /= 2
+			}
+			m.Unlock()
+		}
+		_ = foo
+	})
+}
+
+func BenchmarkMutexHighContention(b *testing.B) {
+	benchmarkMutexHighContention(b, (*Mutex).Lock)
+}
+
+func BenchmarkMutexHighContentionBackoff(b *testing.B) {
+	benchmarkMutexHighContention(b, func(m *Mutex) {
+		m.LockBackoff(time.Microsecond, 100*time.Microsecond)
+	})
+}
+
+func BenchmarkMutexHighContentionRelax(b *testing.B) {
+	for _, pauseCount := range []int{0, 1, 10, 100} {
+		b.Run(fmt.Sprint("pause=", pauseCount), func(b *testing.B) {
+			benchmarkMutexHighContention(b, func(m *Mutex) {
+				m.LockRelax(pauseCount)
+			})
+		})
+	}
+}
+
+func benchmarkMutexPriority(b *testing.B, p Priority) {
+	var m Mutex
+	var stop int32
+	var wg sync.WaitGroup
+	// Background contention
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				m.Lock()
+				for i := 0; i < 100; i++ {
+				}
+				m.Unlock()
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.LockPriority(p)
+		m.Unlock()
+	}
+	b.StopTimer()
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+}
+
+func BenchmarkMutexPriorityLow(b *testing.B) {
+	benchmarkMutexPriority(b, LowPriority)
+}
+
+func BenchmarkMutexPriorityHigh(b *testing.B) {
+	benchmarkMutexPriority(b, HighPriority)
+}
+
+// The Mutex and Atomic counter benchmarks compare a lock-guarded counter with
+// a bare atomic counter. An uncontended Lock/Unlock pair costs two atomic
+// read-modify-write operations, a compare-and-swap and an add, which
+// BenchmarkAtomicLockPair measures as the lower bound for the Mutex benchmarks.
+// Both Lock and Unlock are inlined, thus only the barging threshold load comes
+// on top.
+
+func BenchmarkMutexCounter(b *testing.B) {
+	var mu Mutex
+	var counter int64
+	for i := 0; i < b.N; i++ {
+		mu.Lock()
+		counter++
+		mu.Unlock()
+	}
+	_ = counter
+}
+
+func BenchmarkAtomicCounter(b *testing.B) {
+	var counter int64
+	for i := 0; i < b.N; i++ {
+		atomic.AddInt64(&counter, 1)
+	}
+}
+
+func BenchmarkAtomicLockPair(b *testing.B) {
+	var state int32
+	for i := 0; i < b.N; i++ {
+		atomic.CompareAndSwapInt32(&state, mutexUnlocked, mutexLocked)
+		atomic.AddInt32(&state, -mutexLocked)
+	}
+}
+
+func BenchmarkMutexCounterParallel(b *testing.B) {
+	var mu Mutex
+	var counter int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mu.Lock()
+			counter++
+			mu.Unlock()
+		}
+	})
+	_ = counter
+}
+
+func BenchmarkAtomicCounterParallel(b *testing.B) {
+	var counter int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			atomic.AddInt64(&counter, 1)
+		}
+	})
+}
@@ -0,0 +1,40 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !spinlockdebug
+
+package spinlock
+
+import (
+	"time"
+)
+
+const debug = false
+
+type lockDebug struct{}
+
+type mutexDebug struct {
+	lockDebug
+}
+
+type rwmutexDebug struct {
+	lockDebug
+}
+
+func (*lockDebug) setLevel(int) {}
+func (*lockDebug) acquire()     {}
+func (*lockDebug) acquired()    {}
+func (*lockDebug) release()     {}
+
+func (*mutexDebug) contended(bool)                   {}
+func (*mutexDebug) spinYieldRatio() (uint64, uint64) { return 0, 0 }
+
+func (*mutexDebug) maxHoldTime() (time.Duration, string, int) { return 0, "", 0 }
+
+func (*rwmutexDebug) setReadOwnership() {}
+func (*rwmutexDebug) acquiredRead()     {}
+func (*rwmutexDebug) releaseRead()      {}
+func (*rwmutexDebug) acquireWrite()     {}
+func (*rwmutexDebug) acquiredWrite()    {}
+func (*rwmutexDebug) releaseWrite()     {}
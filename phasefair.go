@@ -0,0 +1,83 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync/atomic"
+)
+
+// A PhaseFairRWMutex is a phase-fair reader/writer mutual exclusion lock.
+// Read and write phases alternate: a waiting writer only waits for the readers
+// which arrived before it, while readers arriving later wait until the writer
+// released the lock. Thus neither readers nor writers can starve. Writers are
+// served in FIFO order.
+// The zero value for a PhaseFairRWMutex is an unlocked mutex.
+//
+// The implementation is the PF-T lock by Brandenburg and Anderson.
+type PhaseFairRWMutex struct {
+	rin  uint32 // Entered readers (upper bits) and writer phase (lower bits)
+	rout uint32 // Exited readers
+	win  uint32 // Next writer ticket
+	wout uint32 // Currently served writer ticket
+}
+
+const (
+	pfReadOffset = 1 << 8 // Bits 9-32 of rin and rout count readers
+	pfPhaseID    = 1 << 0 // Parity of the ticket of the present writer
+	pfPresent    = 1 << 1 // A writer is present
+	pfWriterBits = pfPresent | pfPhaseID
+)
+
+// RLock locks rw for reading.
+// If a writer is present, RLock waits until it released the lock.
+func (rw *PhaseFairRWMutex) RLock() {
+	w := atomic.AddUint32(&rw.rin, pfReadOffset) & pfWriterBits
+	if w == 0 {
+		return
+	}
+
+	// Wait until the phase of the present writer is over
+	spinUntil(func() bool {
+		return atomic.LoadUint32(&rw.rin)&pfWriterBits != w
+	})
+}
+
+// RUnlock undoes a single RLock call.
+func (rw *PhaseFairRWMutex) RUnlock() {
+	atomic.AddUint32(&rw.rout, pfReadOffset)
+}
+
+// Lock locks rw for writing.
+// Lock waits for previously arrived writers and for the readers which arrived
+// before it, but not for readers arriving while it waits.
+func (rw *PhaseFairRWMutex) Lock() {
+	// Wait for our turn among the writers
+	ticket := atomic.AddUint32(&rw.win, 1) - 1
+	if atomic.LoadUint32(&rw.wout) != ticket {
+		spinUntil(func() bool {
+			return atomic.LoadUint32(&rw.wout) == ticket
+		})
+	}
+
+	// Block new readers and wait for the present ones to leave
+	w := pfPresent | ticket&pfPhaseID
+	rtail := atomic.AddUint32(&rw.rin, w) - w
+	if atomic.LoadUint32(&rw.rout) != rtail {
+		spinUntil(func() bool {
+			return atomic.LoadUint32(&rw.rout) == rtail
+		})
+	}
+}
+
+// Unlock unlocks rw for writing.
+// It is a run-time error if rw is not locked for writing on entry to Unlock.
+func (rw *PhaseFairRWMutex) Unlock() {
+	w := atomic.LoadUint32(&rw.rin) & pfWriterBits
+	if w == 0 {
+		panic("spinlock: Unlock of unlocked PhaseFairRWMutex")
+	}
+	atomic.AddUint32(&rw.rin, -w)
+	atomic.AddUint32(&rw.wout, 1)
+}
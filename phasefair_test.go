@@ -0,0 +1,110 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPhaseFairRWMutex(t *testing.T) {
+	const iterations = 1000
+
+	var rw PhaseFairRWMutex
+	hammerRWLocker(t, &rw, 4, iterations)
+}
+
+func TestPhaseFairRWMutexPhases(t *testing.T) {
+	var rw PhaseFairRWMutex
+
+	// A reader holds the lock when the writer arrives
+	rw.RLock()
+	wlocked := make(chan bool)
+	wunlock := make(chan bool)
+	go func() {
+		rw.Lock()
+		wlocked <- true
+		<-wunlock
+		rw.Unlock()
+	}()
+	for atomic.LoadUint32(&rw.rin)&pfPresent == 0 {
+		runtime.Gosched()
+	}
+
+	// Readers arriving after the writer must wait for it
+	rlocked := make(chan bool)
+	go func() {
+		rw.RLock()
+		rlocked <- true
+		rw.RUnlock()
+	}()
+	select {
+	case <-wlocked:
+		t.Fatal("writer did not wait for the present reader")
+	case <-rlocked:
+		t.Fatal("late reader did not wait for the waiting writer")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// The present reader is not aborted and lets the writer in on release
+	rw.RUnlock()
+	<-wlocked
+	select {
+	case <-rlocked:
+		t.Fatal("late reader acquired the lock while the writer holds it")
+	case <-time.After(10 * time.Millisecond):
+	}
+	wunlock <- true
+	<-rlocked
+}
+
+func TestPhaseFairRWMutexWriterNotStarved(t *testing.T) {
+	var rw PhaseFairRWMutex
+	var stop int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				rw.RLock()
+				for i := 0; i < 100; i++ {
+				}
+				rw.RUnlock()
+			}
+		}()
+	}
+
+	// Continuous reader arrival must not prevent the writer from acquiring
+	// the lock
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 10; i++ {
+			rw.Lock()
+			rw.Unlock()
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("writer starved by continuously arriving readers")
+	}
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+}
+
+func TestPhaseFairRWMutexUnlockPanic(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("unlock of unlocked PhaseFairRWMutex did not panic")
+		}
+	}()
+	var rw PhaseFairRWMutex
+	rw.Unlock()
+}
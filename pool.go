@@ -0,0 +1,63 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+// A Pool is a set of reusable objects of type T, guarded by a Mutex.
+// Unlike sync.Pool, objects are never evicted by the garbage collector and are
+// returned by Get in last-in first-out order, which makes the behavior of the
+// pool deterministic.
+// The zero value for a Pool is an empty, unbounded pool without New function.
+// A Pool must not be copied after first use.
+type Pool[T any] struct {
+	// New optionally specifies a function to construct a value when Get is
+	// called on an empty pool.
+	New func() T
+
+	// MaxSize optionally limits the number of pooled objects. Objects put
+	// into a full pool are dropped. MaxSize <= 0 means no limit.
+	MaxSize int
+
+	mu    Mutex
+	items []T
+}
+
+// Get removes the most recently put object from p and returns it.
+// If p is empty, the result of calling p.New is returned, or the zero value
+// of T if p.New is nil.
+func (p *Pool[T]) Get() T {
+	var v T
+	p.mu.Lock()
+	if n := len(p.items) - 1; n >= 0 {
+		v = p.items[n]
+		var zero T
+		p.items[n] = zero // Do not retain references to removed objects
+		p.items = p.items[:n]
+		p.mu.Unlock()
+		return v
+	}
+	p.mu.Unlock()
+
+	if p.New != nil {
+		v = p.New()
+	}
+	return v
+}
+
+// Put adds v to p, unless p already holds MaxSize objects.
+func (p *Pool[T]) Put(v T) {
+	p.mu.Lock()
+	if p.MaxSize <= 0 || len(p.items) < p.MaxSize {
+		p.items = append(p.items, v)
+	}
+	p.mu.Unlock()
+}
+
+// Len returns the number of objects in p.
+func (p *Pool[T]) Len() int {
+	p.mu.Lock()
+	n := len(p.items)
+	p.mu.Unlock()
+	return n
+}
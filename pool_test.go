@@ -0,0 +1,85 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	var p Pool[*int]
+	if v := p.Get(); v != nil {
+		t.Fatalf("Get of empty pool without New returned %v, expected nil", v)
+	}
+
+	created := 0
+	p.New = func() *int {
+		created++
+		return new(int)
+	}
+	if v := p.Get(); v == nil || created != 1 {
+		t.Fatal("Get of empty pool did not construct an object")
+	}
+
+	a, b := new(int), new(int)
+	p.Put(a)
+	p.Put(b)
+	runtime.GC() // Pooled objects are not evicted
+	if v := p.Get(); v != b {
+		t.Fatal("Get did not return the most recently put object")
+	}
+	if v := p.Get(); v != a {
+		t.Fatal("Get did not return the put object")
+	}
+	if created != 1 {
+		t.Fatalf("%d objects constructed, expected 1", created)
+	}
+}
+
+func TestPoolMaxSize(t *testing.T) {
+	p := Pool[int]{MaxSize: 2}
+	for i := 1; i <= 3; i++ {
+		p.Put(i)
+	}
+	if n := p.Len(); n != 2 {
+		t.Fatalf("full pool holds %d objects, expected 2", n)
+	}
+	if v := p.Get(); v != 2 {
+		t.Fatalf("Get returned %d, expected 2", v)
+	}
+}
+
+func TestPoolConcurrent(t *testing.T) {
+	const goroutines = 8
+	const iterations = 1000
+
+	type object struct {
+		inUse bool
+	}
+	p := Pool[*object]{New: func() *object { return new(object) }}
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				o := p.Get()
+				if o.inUse {
+					t.Error("object handed out twice")
+				}
+				o.inUse = true
+				o.inUse = false
+				p.Put(o)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := p.Len(); n < 1 || n > goroutines {
+		t.Fatalf("pool holds %d objects, expected between 1 and %d", n, goroutines)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package spinlock
+
+import (
+	"os"
+	"syscall"
+)
+
+// A ProcessMutex is a mutual exclusion lock across goroutines and across
+// processes on the same host. Goroutines of a process are excluded by a
+// Mutex, processes by an advisory file lock (flock) on a lock file.
+// The file lock is only requested by the goroutine holding the Mutex, thus
+// other goroutines of the process busy wait for the Mutex meanwhile, like for
+// any locked Mutex.
+// A ProcessMutex must be created with NewProcessMutex.
+type ProcessMutex struct {
+	mu   Mutex
+	file *os.File
+}
+
+// NewProcessMutex returns a ProcessMutex using the lock file at path, which is
+// created if it does not exist. All processes must use the same path.
+func NewProcessMutex(path string) (*ProcessMutex, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &ProcessMutex{file: f}, nil
+}
+
+// Lock locks m.
+// If the lock is already in use by another goroutine or process, Lock blocks
+// until the lock is available.
+func (m *ProcessMutex) Lock() {
+	m.mu.Lock()
+	if err := m.flock(syscall.LOCK_EX); err != nil {
+		m.mu.Unlock()
+		panic("spinlock: flock of ProcessMutex failed: " + err.Error())
+	}
+}
+
+// TryLock tries to lock m.
+// If the lock is already in use by another goroutine or process, the lock is
+// not acquired and false is returned.
+// It panics if the file lock fails for any other reason.
+func (m *ProcessMutex) TryLock() bool {
+	if !m.mu.TryLock() {
+		return false
+	}
+	if err := m.flock(syscall.LOCK_EX | syscall.LOCK_NB); err != nil {
+		m.mu.Unlock()
+		if err == syscall.EWOULDBLOCK {
+			return false
+		}
+		panic("spinlock: flock of ProcessMutex failed: " + err.Error())
+	}
+	return true
+}
+
+// Unlock unlocks m.
+// It is a run-time error if m is not locked on entry to Unlock.
+// If releasing the file lock fails, Unlock still unlocks m within the process
+// before it panics.
+func (m *ProcessMutex) Unlock() {
+	err := m.flock(syscall.LOCK_UN)
+	m.mu.Unlock()
+	if err != nil {
+		panic("spinlock: flock of ProcessMutex failed: " + err.Error())
+	}
+}
+
+// Close closes the lock file. m must not be locked and must not be used
+// afterwards.
+func (m *ProcessMutex) Close() error {
+	return m.file.Close()
+}
+
+func (m *ProcessMutex) flock(how int) error {
+	for {
+		err := syscall.Flock(int(m.file.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package spinlock
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// processMutexHelperEnv is set to the lock file path when the test binary is
+// run as a helper process by TestProcessMutexInterProcess.
+const processMutexHelperEnv = "SPINLOCK_PROCESS_MUTEX_HELPER"
+
+func TestProcessMutex(t *testing.T) {
+	const iterations = 1000
+
+	m, err := NewProcessMutex(filepath.Join(t.TempDir(), "lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	hammerLocker(t, iterations, m, m, m, m)
+
+	m.Lock()
+	if m.TryLock() {
+		t.Fatal("TryLock succeeded while locked")
+	}
+	m.Unlock()
+	if !m.TryLock() {
+		t.Fatal("TryLock failed while unlocked")
+	}
+	m.Unlock()
+}
+
+func TestProcessMutexFlockError(t *testing.T) {
+	m, err := NewProcessMutex(filepath.Join(t.TempDir(), "lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flock of the closed file fails with EBADF
+	m.Lock()
+	m.Close()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("Unlock with failing flock did not panic")
+			}
+		}()
+		m.Unlock()
+	}()
+	if !m.mu.TryLock() {
+		t.Fatal("Unlock with failing flock left the Mutex locked")
+	}
+	m.mu.Unlock()
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("TryLock with failing flock did not panic")
+			}
+		}()
+		m.TryLock()
+	}()
+	if !m.mu.TryLock() {
+		t.Fatal("TryLock with failing flock left the Mutex locked")
+	}
+	m.mu.Unlock()
+}
+
+// TestProcessMutexHelper is not a real test. It is run in a helper process
+// and reports whether it could acquire the lock.
+func TestProcessMutexHelper(t *testing.T) {
+	path := os.Getenv(processMutexHelperEnv)
+	if path == "" {
+		t.Skip("only run as helper process")
+	}
+	m, err := NewProcessMutex(path)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if m.TryLock() {
+		fmt.Println("acquired")
+		m.Unlock()
+	} else {
+		fmt.Println("locked")
+	}
+	os.Exit(0)
+}
+
+func TestProcessMutexInterProcess(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping multi-process test in short mode")
+	}
+	path := filepath.Join(t.TempDir(), "lock")
+	tryLock := func() string {
+		t.Helper()
+		cmd := exec.Command(os.Args[0], "-test.run=^TestProcessMutexHelper$")
+		cmd.Env = append(os.Environ(), processMutexHelperEnv+"="+path)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("helper process failed: %v: %s", err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	m, err := NewProcessMutex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	m.Lock()
+	if res := tryLock(); res != "locked" {
+		t.Fatalf("helper process %s lock held by this process", res)
+	}
+	m.Unlock()
+	if res := tryLock(); res != "acquired" {
+		t.Fatalf("helper process could not acquire the released lock: %s", res)
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !tinygo
+
+package spinlock
+
+import (
+	_ "unsafe" // for go:linkname
+)
+
+// procyield executes the given number of CPU pause instructions (PAUSE on x86,
+// YIELD on arm64).
+//
+//go:linkname procyield runtime.procyield
+func procyield(cycles uint32)
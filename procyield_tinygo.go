@@ -0,0 +1,23 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build tinygo
+
+package spinlock
+
+import (
+	"sync/atomic"
+)
+
+// procyieldWord is loaded by each iteration of procyield.
+var procyieldWord uint32
+
+// procyield busy waits for about the given number of cycles. TinyGo does not
+// provide runtime.procyield.
+// The atomic load keeps the compiler from removing the otherwise empty loop.
+func procyield(cycles uint32) {
+	for i := uint32(0); i < cycles; i++ {
+		atomic.LoadUint32(&procyieldWord)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// readerSpikesSize is the number of reader spikes retained.
+const readerSpikesSize = 32
+
+// A ReaderSpike is recorded by RWMutex.LockLogging when the number of readers
+// observed while waiting for the write lock exceeded the threshold.
+type ReaderSpike struct {
+	Lock   uintptr       // Address of the RWMutex
+	Time   time.Time     // Time the write lock was acquired
+	Peak   int           // Peak number of readers observed while waiting
+	Waited time.Duration // Time spent waiting for the write lock
+}
+
+var (
+	readerSpikeLogging int32
+
+	readerSpikesMu   Mutex
+	readerSpikes     [readerSpikesSize]ReaderSpike
+	readerSpikesNext int // Index of the next spike to write
+	readerSpikesLen  int
+)
+
+// SetReaderSpikeLogging enables or disables the recording of reader spikes by
+// RWMutex.LockLogging. Disabling it discards the recorded spikes.
+func SetReaderSpikeLogging(enabled bool) {
+	readerSpikesMu.Lock()
+	if enabled {
+		atomic.StoreInt32(&readerSpikeLogging, 1)
+	} else {
+		atomic.StoreInt32(&readerSpikeLogging, 0)
+		readerSpikesNext, readerSpikesLen = 0, 0
+	}
+	readerSpikesMu.Unlock()
+}
+
+// LockLogging locks rw for writing like Lock. If reader spike logging is
+// enabled with SetReaderSpikeLogging and the peak number of readers observed
+// while waiting for the lock exceeded threshold, a ReaderSpike is recorded,
+// which can be retrieved with ReaderSpikes.
+// Using LockLogging instead of Lock for selected writers helps diagnosing
+// intermittent reader spikes that delay writers.
+func (rw *RWMutex) LockLogging(threshold int) {
+	if atomic.LoadInt32(&readerSpikeLogging) == 0 {
+		rw.Lock()
+		return
+	}
+	rw.dbg.acquireWrite()
+	var peak uint32
+	var start time.Time
+	// observe loads the state and updates the peak number of readers
+	observe := func() uint32 {
+		state := atomic.LoadUint32(&rw.state)
+		if readers := state / rwmutexReadOffset; readers > peak {
+			peak = readers
+		}
+		return state
+	}
+	for !atomic.CompareAndSwapUint32(&rw.state, rwmutexUnlocked, rwmutexWrite) {
+		if start.IsZero() {
+			start = time.Now()
+		}
+		for i := spinAttempts(); i > 0; i-- {
+			if observe() == rwmutexUnlocked {
+				break
+			}
+			spinPause()
+		}
+		if observe() != rwmutexUnlocked {
+			yield()
+		}
+	}
+	rw.dbg.acquiredWrite()
+
+	if int(peak) > threshold {
+		now := time.Now()
+		recordReaderSpike(ReaderSpike{
+			Lock:   uintptr(unsafe.Pointer(rw)),
+			Time:   now,
+			Peak:   int(peak),
+			Waited: now.Sub(start),
+		})
+	}
+}
+
+func recordReaderSpike(spike ReaderSpike) {
+	readerSpikesMu.Lock()
+	defer readerSpikesMu.Unlock()
+	if atomic.LoadInt32(&readerSpikeLogging) == 0 {
+		return // Disabled meanwhile
+	}
+	readerSpikes[readerSpikesNext] = spike
+	readerSpikesNext = (readerSpikesNext + 1) % readerSpikesSize
+	if readerSpikesLen < readerSpikesSize {
+		readerSpikesLen++
+	}
+}
+
+// ReaderSpikes returns the most recently recorded reader spikes, oldest first.
+// At most 32 spikes are retained.
+func ReaderSpikes() []ReaderSpike {
+	readerSpikesMu.Lock()
+	defer readerSpikesMu.Unlock()
+	spikes := make([]ReaderSpike, 0, readerSpikesLen)
+	for i := readerSpikesLen; i > 0; i-- {
+		spikes = append(spikes, readerSpikes[(readerSpikesNext-i+readerSpikesSize)%readerSpikesSize])
+	}
+	return spikes
+}
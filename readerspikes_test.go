@@ -0,0 +1,116 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// lockLoggingWithReaders lets readers hold rw while a writer waits in
+// LockLogging and returns the spikes recorded by it.
+func lockLoggingWithReaders(t *testing.T, readers, threshold int) []ReaderSpike {
+	var rw RWMutex
+	before := len(ReaderSpikes())
+	for i := 0; i < readers; i++ {
+		rw.RLock()
+	}
+
+	locked := make(chan bool)
+	go func() {
+		rw.LockLogging(threshold)
+		locked <- true
+	}()
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < readers; i++ {
+		rw.RUnlock()
+	}
+	<-locked
+	if atomic.LoadUint32(&rw.state) != rwmutexWrite {
+		t.Fatal("LockLogging did not acquire the write lock")
+	}
+	rw.Unlock()
+
+	spikes := ReaderSpikes()
+	if before == readerSpikesSize {
+		// The ring buffer is full; compare the newest entry instead
+		spikes = spikes[len(spikes)-1:]
+	} else {
+		spikes = spikes[before:]
+	}
+	for _, spike := range spikes {
+		if spike.Lock != uintptr(unsafe.Pointer(&rw)) {
+			t.Fatalf("spike of lock %#x recorded, expected %p", spike.Lock, &rw)
+		}
+	}
+	return spikes
+}
+
+func TestLockLogging(t *testing.T) {
+	SetReaderSpikeLogging(true)
+	defer SetReaderSpikeLogging(false)
+
+	spikes := lockLoggingWithReaders(t, 5, 3)
+	if len(spikes) != 1 {
+		t.Fatalf("%d reader spikes recorded, expected 1", len(spikes))
+	}
+	if spikes[0].Peak != 5 {
+		t.Fatalf("recorded peak of %d readers, expected 5", spikes[0].Peak)
+	}
+	if spikes[0].Waited <= 0 {
+		t.Fatalf("recorded waiting time %v is not positive", spikes[0].Waited)
+	}
+
+	before := len(ReaderSpikes())
+	var rw RWMutex
+	rw.LockLogging(0) // uncontended
+	rw.Unlock()
+	for i := 0; i < 2; i++ {
+		rw.RLock()
+	}
+	go func() {
+		runtime.Gosched()
+		rw.RUnlock()
+		rw.RUnlock()
+	}()
+	rw.LockLogging(2) // below threshold
+	rw.Unlock()
+	if n := len(ReaderSpikes()); n != before {
+		t.Fatalf("reader spike below threshold recorded")
+	}
+}
+
+func TestLockLoggingDisabled(t *testing.T) {
+	SetReaderSpikeLogging(true)
+	lockLoggingWithReaders(t, 5, 3)
+	SetReaderSpikeLogging(false)
+	if n := len(ReaderSpikes()); n != 0 {
+		t.Fatalf("%d reader spikes retained after disabling", n)
+	}
+	if spikes := lockLoggingWithReaders(t, 5, 3); len(spikes) != 0 {
+		t.Fatalf("%d reader spikes recorded while disabled", len(spikes))
+	}
+}
+
+func TestReaderSpikesRing(t *testing.T) {
+	SetReaderSpikeLogging(true)
+	defer SetReaderSpikeLogging(false)
+
+	for i := 0; i < readerSpikesSize+5; i++ {
+		recordReaderSpike(ReaderSpike{Peak: 1000 + i})
+	}
+	spikes := ReaderSpikes()
+	if len(spikes) != readerSpikesSize {
+		t.Fatalf("%d reader spikes retained, expected %d", len(spikes), readerSpikesSize)
+	}
+	for i, spike := range spikes {
+		if want := 1005 + i; spike.Peak != want {
+			t.Fatalf("spike %d has peak %d, expected %d", i, spike.Peak, want)
+		}
+	}
+}
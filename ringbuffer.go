@@ -0,0 +1,87 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync/atomic"
+)
+
+// A RingBuffer is a bounded FIFO queue.
+//
+// A RingBuffer created by NewSPSCRingBuffer is lock-free, but may only be used
+// by a single producer goroutine calling Enqueue and a single consumer
+// goroutine calling Dequeue at a time. A RingBuffer created by NewRingBuffer
+// supports multiple producers and consumers by guarding all operations with a
+// Mutex.
+type RingBuffer[T any] struct {
+	head atomic.Uint64 // Index of the next element to dequeue
+	_    [56]byte      // Avoid false sharing between consumer and producer
+	tail atomic.Uint64 // Index of the next element to enqueue
+	_    [56]byte
+
+	mu   Mutex // Only used if !spsc
+	spsc bool
+	buf  []T
+}
+
+// NewRingBuffer returns a RingBuffer with the given capacity for multiple
+// producers and consumers.
+// It panics if capacity is not positive.
+func NewRingBuffer[T any](capacity int) *RingBuffer[T] {
+	if capacity <= 0 {
+		panic("spinlock: non-positive RingBuffer capacity")
+	}
+	return &RingBuffer[T]{buf: make([]T, capacity)}
+}
+
+// NewSPSCRingBuffer returns a lock-free RingBuffer with the given capacity for
+// a single producer and a single consumer.
+// It panics if capacity is not positive.
+func NewSPSCRingBuffer[T any](capacity int) *RingBuffer[T] {
+	r := NewRingBuffer[T](capacity)
+	r.spsc = true
+	return r
+}
+
+// Cap returns the capacity of r.
+func (r *RingBuffer[T]) Cap() int {
+	return len(r.buf)
+}
+
+// Enqueue appends v to r.
+// It returns false if r is full.
+func (r *RingBuffer[T]) Enqueue(v T) bool {
+	if !r.spsc {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+	}
+	tail := r.tail.Load()
+	if tail-r.head.Load() == uint64(len(r.buf)) {
+		return false
+	}
+	r.buf[tail%uint64(len(r.buf))] = v
+	// Publish the element to the consumer
+	r.tail.Store(tail + 1)
+	return true
+}
+
+// Dequeue removes and returns the first element of r.
+// If r is empty, the zero value of T and false are returned.
+func (r *RingBuffer[T]) Dequeue() (T, bool) {
+	if !r.spsc {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+	}
+	var v T
+	head := r.head.Load()
+	if head == r.tail.Load() {
+		return v, false
+	}
+	i := head % uint64(len(r.buf))
+	v, r.buf[i] = r.buf[i], v // Do not retain references to dequeued elements
+	// Release the slot to the producer
+	r.head.Store(head + 1)
+	return v, true
+}
@@ -0,0 +1,110 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func testRingBufferSequential(t *testing.T, r *RingBuffer[int]) {
+	if _, ok := r.Dequeue(); ok {
+		t.Fatal("Dequeue from empty RingBuffer succeeded")
+	}
+	for round := 0; round < 3; round++ {
+		for i := 0; i < r.Cap(); i++ {
+			if !r.Enqueue(i) {
+				t.Fatalf("Enqueue %d failed", i)
+			}
+		}
+		if r.Enqueue(-1) {
+			t.Fatal("Enqueue to full RingBuffer succeeded")
+		}
+		for i := 0; i < r.Cap(); i++ {
+			if v, ok := r.Dequeue(); !ok || v != i {
+				t.Fatalf("Dequeue returned %d, %v, expected %d, true", v, ok, i)
+			}
+		}
+		if _, ok := r.Dequeue(); ok {
+			t.Fatal("Dequeue from empty RingBuffer succeeded")
+		}
+	}
+}
+
+func TestRingBuffer(t *testing.T) {
+	testRingBufferSequential(t, NewRingBuffer[int](5))
+	testRingBufferSequential(t, NewSPSCRingBuffer[int](5))
+}
+
+func TestRingBufferPanic(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("non-positive capacity did not panic")
+		}
+	}()
+	NewRingBuffer[int](0)
+}
+
+// transfer sends n values per producer through r and returns the sum
+// of all received values.
+func transfer(r *RingBuffer[int], producers, consumers, n int) int {
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 1; i <= n; i++ {
+				for !r.Enqueue(i) {
+					runtime.Gosched()
+				}
+			}
+		}()
+	}
+
+	sums := make(chan int)
+	perConsumer := producers * n / consumers
+	for c := 0; c < consumers; c++ {
+		go func() {
+			sum := 0
+			for i := 0; i < perConsumer; i++ {
+				v, ok := r.Dequeue()
+				for ; !ok; v, ok = r.Dequeue() {
+					runtime.Gosched()
+				}
+				sum += v
+			}
+			sums <- sum
+		}()
+	}
+	wg.Wait()
+	sum := 0
+	for c := 0; c < consumers; c++ {
+		sum += <-sums
+	}
+	return sum
+}
+
+func TestRingBufferSPSC(t *testing.T) {
+	const n = 10000
+	if sum := transfer(NewSPSCRingBuffer[int](16), 1, 1, n); sum != n*(n+1)/2 {
+		t.Fatalf("received sum %d, expected %d", sum, n*(n+1)/2)
+	}
+}
+
+func TestRingBufferMPMC(t *testing.T) {
+	const n = 10000
+	if sum := transfer(NewRingBuffer[int](16), 4, 4, n); sum != 4*n*(n+1)/2 {
+		t.Fatalf("received sum %d, expected %d", sum, 4*n*(n+1)/2)
+	}
+}
+
+func BenchmarkRingBufferSPSC(b *testing.B) {
+	transfer(NewSPSCRingBuffer[int](1024), 1, 1, b.N)
+}
+
+func BenchmarkRingBufferMPMC(b *testing.B) {
+	transfer(NewRingBuffer[int](1024), 1, 1, b.N)
+}
@@ -5,7 +5,8 @@
 package spinlock
 
 import (
-	"runtime"
+	"context"
+	"math"
 	"sync"
 	"sync/atomic"
 )
@@ -17,6 +18,7 @@ import (
 // structures; the zero value for a RWMutex is
 // an unlocked mutex.
 type RWMutex struct {
+	dbg   rwmutexDebug
 	state uint32
 }
 
@@ -27,25 +29,45 @@ const (
 	rwmutexUnderflow      = ^uint32(rwmutexWrite)
 	rwmutexWriterUnset    = ^uint32(rwmutexWrite - 1)
 	rwmutexReaderDecrease = ^uint32(rwmutexReadOffset - 1)
+	rwmutexMaxReaders     = math.MaxUint32 / rwmutexReadOffset
 )
 
 // RLock locks rw for reading.
+// A waiting reader is registered before it waits for the writer to leave, which
+// prevents new writers from acquiring the lock. Thus a reader waits for at most
+// one write critical section and can not be starved by a continuous stream of
+// writers.
 func (rw *RWMutex) RLock() {
+	rw.dbg.acquire()
+
 	// Increase the number of readers by 1
 	state := atomic.AddUint32(&rw.state, rwmutexReadOffset)
 
-	// If no write bits are set, the read lock was successfully acquired
-	if state&rwmutexWrite == 0 {
-		return
+	// If write bits are set, we have to wait until they become unset.
+	// Afterwards the RWMutex is in read mode.
+	if state&rwmutexWrite != 0 {
+		rw.rlockSlow()
 	}
+	rw.dbg.acquiredRead()
+}
 
-	// Otherwise we have to wait until the write bits become unset.
-	// Afterwards the RWMutex is in read mode.
+// rlockSlow waits for the writer to unlock rw.
+// The reader was already registered by RLock, thus waiting readers only load
+// the state. When the writer unlocks rw, all waiting readers are admitted at
+// once without writing to the state again.
+func (rw *RWMutex) rlockSlow() {
+	recordContention()
 	for {
+		for i := spinAttempts(); i > 0; i-- {
+			if state := atomic.LoadUint32(&rw.state); state&rwmutexWrite == 0 {
+				return
+			}
+			spinPause()
+		}
+		yield()
 		if state := atomic.LoadUint32(&rw.state); state&rwmutexWrite == 0 {
 			return
 		}
-		runtime.Gosched()
 	}
 }
 
@@ -57,6 +79,7 @@ func (rw *RWMutex) TryRLock() bool {
 
 	// If no write bits are set, the read lock was successfully acquired
 	if state&rwmutexWrite == 0 {
+		rw.dbg.acquiredRead()
 		return true
 	}
 
@@ -65,11 +88,69 @@ func (rw *RWMutex) TryRLock() bool {
 	return false
 }
 
+// RLockNoYield tries to lock rw for reading by busy waiting only. Unlike
+// RLock it never yields the processor or calls into the scheduler, which
+// makes it usable in restricted contexts where blocking is forbidden.
+// If the read lock can not be acquired within SpinBudget attempts, or fewer
+// in SMT mode (see SetSMTMode), false is returned.
+// The calling goroutine may still be preempted by the runtime. If the lock
+// is write-locked by a goroutine which can only run once the caller yields,
+// e.g. with GOMAXPROCS=1, RLockNoYield fails.
+func (rw *RWMutex) RLockNoYield() bool {
+	// Increase the number of readers by 1, keeping new writers out
+	state := atomic.AddUint32(&rw.state, rwmutexReadOffset)
+	for i := spinAttempts(); state&rwmutexWrite != 0; i-- {
+		if i <= 0 {
+			// Undo
+			atomic.AddUint32(&rw.state, rwmutexReaderDecrease)
+			return false
+		}
+		spinPause()
+		state = atomic.LoadUint32(&rw.state)
+	}
+	rw.dbg.acquiredRead()
+	return true
+}
+
+// TryRLockN tries to lock rw for reading n times at once.
+// Either all n read locks are acquired or none of them is, in which case false
+// is returned. On success, RUnlock must be called n times.
+// It panics if n is not positive or the number of readers would exceed the
+// capacity of rw, 2^31-1.
+func (rw *RWMutex) TryRLockN(n int) bool {
+	if n <= 0 || n > rwmutexMaxReaders {
+		panic("spinlock: TryRLockN with n out of range")
+	}
+	delta := uint32(n) * rwmutexReadOffset
+
+	// Increase the number of readers by n
+	state := atomic.AddUint32(&rw.state, delta)
+	if state/rwmutexReadOffset < uint32(n) {
+		// The reader count overflowed
+		atomic.AddUint32(&rw.state, -delta)
+		panic("spinlock: TryRLockN exceeds the reader capacity of RWMutex")
+	}
+
+	// If no write bits are set, the read locks were successfully acquired
+	if state&rwmutexWrite == 0 {
+		for i := 0; i < n; i++ {
+			rw.dbg.acquiredRead()
+		}
+		return true
+	}
+
+	// Undo all n
+	atomic.AddUint32(&rw.state, -delta)
+	return false
+}
+
 // RUnlock undoes a single RLock call;
 // it does not affect other simultaneous readers.
 // It is a run-time error if rw is not locked for reading
 // on entry to RUnlock.
 func (rw *RWMutex) RUnlock() {
+	rw.dbg.releaseRead()
+
 	// Decrease the number of readers by 1
 	state := atomic.AddUint32(&rw.state, rwmutexReaderDecrease)
 
@@ -79,19 +160,76 @@ func (rw *RWMutex) RUnlock() {
 	}
 }
 
+// ReadView locks rw for reading and returns a ReadView holding the read lock
+// until it is closed. It is intended to make a block of related reads
+// explicit:
+//
+//	v := rw.ReadView()
+//	defer v.Close()
+func (rw *RWMutex) ReadView() ReadView {
+	rw.RLock()
+	return ReadView{rw: rw}
+}
+
+// A ReadView holds a read lock of an RWMutex until Close is called.
+type ReadView struct {
+	rw *RWMutex
+}
+
+// Close releases the read lock held by v.
+// It is a run-time error if v is closed more than once.
+func (v *ReadView) Close() {
+	if v.rw == nil {
+		panic("spinlock: Close of closed ReadView")
+	}
+	rw := v.rw
+	v.rw = nil
+	rw.RUnlock()
+}
+
 // Lock locks rw for writing.
 // If the lock is already locked for reading or writing,
 // Lock blocks until the lock is available.
 func (rw *RWMutex) Lock() {
-	for !atomic.CompareAndSwapUint32(&rw.state, rwmutexUnlocked, rwmutexWrite) {
-		runtime.Gosched()
+	rw.dbg.acquireWrite()
+	if !atomic.CompareAndSwapUint32(&rw.state, rwmutexUnlocked, rwmutexWrite) {
+		rw.lockSlow()
 	}
+	rw.dbg.acquiredWrite()
+}
+
+func (rw *RWMutex) lockSlow() {
+	recordContention()
+	for !rw.spinTryLock(spinAttempts()) {
+		yield()
+	}
+}
+
+// spinTryLock tries to lock rw for writing, retrying up to budget times while
+// busy waiting.
+func (rw *RWMutex) spinTryLock(budget int) bool {
+	if atomic.CompareAndSwapUint32(&rw.state, rwmutexUnlocked, rwmutexWrite) {
+		return true
+	}
+	for i := budget; i > 0; i-- {
+		if atomic.LoadUint32(&rw.state) == rwmutexUnlocked &&
+			atomic.CompareAndSwapUint32(&rw.state, rwmutexUnlocked, rwmutexWrite) {
+			return true
+		}
+		spinPause()
+	}
+	return false
 }
 
 // TryLock tries to lock rw for writing.
 // If the lock for writing can not be acquired immediately, false is returned.
 func (rw *RWMutex) TryLock() bool {
-	return atomic.CompareAndSwapUint32(&rw.state, rwmutexUnlocked, rwmutexWrite)
+	if testBeforeTryLock() && atomic.LoadUint32(&rw.state) != rwmutexUnlocked ||
+		!atomic.CompareAndSwapUint32(&rw.state, rwmutexUnlocked, rwmutexWrite) {
+		return false
+	}
+	rw.dbg.acquiredWrite()
+	return true
 }
 
 // Unlock unlocks rw for writing.  It is a run-time error if rw is
@@ -101,6 +239,8 @@ func (rw *RWMutex) TryLock() bool {
 // goroutine.  One goroutine may RLock (Lock) an RWMutex and then
 // arrange for another goroutine to RUnlock (Unlock) it.
 func (rw *RWMutex) Unlock() {
+	rw.dbg.releaseWrite()
+
 	// Unset the Write bit
 	state := atomic.AddUint32(&rw.state, rwmutexWriterUnset)
 	if state&rwmutexWrite > 0 {
@@ -108,13 +248,98 @@ func (rw *RWMutex) Unlock() {
 	}
 }
 
-// RLocker returns a Locker interface that implements
-// the Lock and Unlock methods by calling rw.RLock and rw.RUnlock.
-func (rw *RWMutex) RLocker() sync.Locker {
+// TryUpgrade tries to atomically upgrade the read lock held by the caller to
+// the write lock. It succeeds only if the caller is the sole reader, in which
+// case rw is locked for writing without ever being unlocked in between, and
+// must then be unlocked with Unlock.
+// If other readers hold rw, false is returned and the caller still holds its
+// read lock; TryUpgrade never releases it.
+//
+// A caller must not wait for TryUpgrade to succeed while holding the read
+// lock: two readers doing so wait for each other forever. Instead, drop the
+// read lock with RUnlock, acquire the write lock with Lock and re-validate the
+// state read before.
+// It is a run-time error if rw is not locked for reading on entry to
+// TryUpgrade.
+func (rw *RWMutex) TryUpgrade() bool {
+	state := atomic.LoadUint32(&rw.state)
+	if state&rwmutexWrite != 0 || state < rwmutexReadOffset {
+		panic("spinlock: TryUpgrade of RWMutex not locked for reading")
+	}
+	if state != rwmutexReadOffset ||
+		!atomic.CompareAndSwapUint32(&rw.state, rwmutexReadOffset, rwmutexWrite) {
+		return false
+	}
+	rw.dbg.releaseRead()
+	rw.dbg.acquiredWrite()
+	return true
+}
+
+// WriteBatch locks rw for writing once, calls all ops in order and unlocks rw,
+// even if an op panics. This amortizes the cost of acquiring the lock over
+// bursts of consecutive write operations.
+// Readers are excluded for the whole batch, thus large batches increase the
+// latency of readers.
+func (rw *RWMutex) WriteBatch(ops []func()) {
+	rw.Lock()
+	defer rw.Unlock()
+	for _, op := range ops {
+		op()
+	}
+}
+
+// WaitQuiescent waits until rw is observed completely unlocked, i.e. neither
+// locked for reading nor for writing. Unlike Lock it does not acquire rw.
+// The result is inherently racy, as rw might be locked again immediately
+// afterwards; it is only useful for best-effort quiescence detection.
+func (rw *RWMutex) WaitQuiescent() {
+	if atomic.LoadUint32(&rw.state) == rwmutexUnlocked {
+		return
+	}
+	spinUntil(func() bool {
+		return atomic.LoadUint32(&rw.state) == rwmutexUnlocked
+	})
+}
+
+// SetLevel assigns rw a level for detecting lock order violations, see
+// Mutex.SetLevel. Read and write locks of rw are both treated as holding rw.
+// SetLevel must be called before rw is used. It only has an effect in debug
+// builds (built with the spinlockdebug tag).
+func (rw *RWMutex) SetLevel(level int) {
+	rw.dbg.setLevel(level)
+}
+
+// SetReadOwnership declares that read locks of rw are always released by the
+// goroutine that acquired them, which RWMutex does not require otherwise.
+// RUnlock by a goroutine not holding a read lock of rw then panics, instead of
+// silently releasing the read lock of another goroutine.
+// SetReadOwnership must be called before rw is used. It only has an effect in
+// debug builds (built with the spinlockdebug tag).
+func (rw *RWMutex) SetReadOwnership() {
+	rw.dbg.setReadOwnership()
+}
+
+// An RLockerFull is a sync.Locker supporting non-blocking and cancellable
+// acquisition, as returned by RWMutex.RLocker.
+type RLockerFull interface {
+	sync.Locker
+	TryLock() bool
+	LockContext(ctx context.Context) error
+}
+
+// RLocker returns an RLockerFull interface that implements the Lock, Unlock,
+// TryLock and LockContext methods by calling rw.RLock, rw.RUnlock,
+// rw.TryRLock and rw.RLockContext.
+func (rw *RWMutex) RLocker() RLockerFull {
 	return (*rlocker)(rw)
 }
 
 type rlocker RWMutex
 
-func (r *rlocker) Lock()   { (*RWMutex)(r).RLock() }
-func (r *rlocker) Unlock() { (*RWMutex)(r).RUnlock() }
+func (r *rlocker) Lock()         { (*RWMutex)(r).RLock() }
+func (r *rlocker) Unlock()       { (*RWMutex)(r).RUnlock() }
+func (r *rlocker) TryLock() bool { return (*RWMutex)(r).TryRLock() }
+
+func (r *rlocker) LockContext(ctx context.Context) error {
+	return (*RWMutex)(r).RLockContext(ctx)
+}
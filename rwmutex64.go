@@ -0,0 +1,216 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync/atomic"
+)
+
+const (
+	rw64Write          = 1 << 0 // Bit 1 is used as a flag for write mode
+	rw64WriteIntent    = 1 << 1 // Bit 2 is set while a writer is waiting
+	rw64Upgrade        = 1 << 2 // Bit 3 is set while a reader is upgrading
+	rw64ReadOffset     = 1 << 8 // Bits 9-64 store the number of readers
+	rw64ReaderMask     = ^uint64(rw64ReadOffset - 1)
+	rw64ReaderDecrease = ^uint64(rw64ReadOffset - 1)
+	rw64WriterUnset    = ^uint64(rw64Write - 1)
+)
+
+// An RWMutex64 is a reader/writer mutual exclusion lock like RWMutex, but with
+// a 64-bit state. It supports up to 2^56-1 simultaneous readers and reserves
+// flag bits for advanced modes.
+//
+// Unlike RWMutex, RWMutex64 prefers writers: a waiting writer sets a
+// write-intent flag, which prevents new readers from acquiring the lock until
+// the writer acquired it. Readers holding the lock are not affected.
+//
+// The zero value for a RWMutex64 is an unlocked mutex.
+type RWMutex64 struct {
+	dbg     rwmutexDebug
+	state   atomic.Uint64 // 8-byte aligned on all platforms
+	writers atomic.Int32  // Number of writers waiting in Lock
+}
+
+// RLock locks rw for reading.
+// If rw is locked for writing or a writer is waiting or upgrading, RLock waits
+// until the writer unlocked rw.
+func (rw *RWMutex64) RLock() {
+	rw.dbg.acquire()
+	if !rw.tryRLock() {
+		rw.rlockSlow()
+	}
+	rw.dbg.acquiredRead()
+}
+
+func (rw *RWMutex64) rlockSlow() {
+	recordContention()
+	spinUntil(rw.tryRLock)
+}
+
+// tryRLock tries to increase the number of readers by 1, unless rw is locked
+// for writing or a writer is waiting.
+func (rw *RWMutex64) tryRLock() bool {
+	for {
+		state := rw.state.Load()
+		if state&(rw64Write|rw64WriteIntent|rw64Upgrade) != 0 {
+			return false
+		}
+		if rw.state.CompareAndSwap(state, state+rw64ReadOffset) {
+			return true
+		}
+	}
+}
+
+// TryRLock tries to lock rw for reading.
+// If a lock for reading can not be acquired immediately, false is returned.
+func (rw *RWMutex64) TryRLock() bool {
+	if !rw.tryRLock() {
+		return false
+	}
+	rw.dbg.acquiredRead()
+	return true
+}
+
+// RUnlock undoes a single RLock call;
+// it does not affect other simultaneous readers.
+// It is a run-time error if rw is not locked for reading
+// on entry to RUnlock.
+func (rw *RWMutex64) RUnlock() {
+	rw.dbg.releaseRead()
+
+	// Decrease the number of readers by 1
+	state := rw.state.Add(rw64ReaderDecrease)
+
+	// Check for underflow
+	if state&rw64ReaderMask == rw64ReaderMask {
+		panic("spinlock: RUnlock of unlocked RWMutex64")
+	}
+}
+
+// RYield temporarily releases the read lock held by the caller if a writer
+// or a reader upgrading with UpgradeBlocking is waiting, giving it a chance to
+// acquire the lock, and locks rw for reading again afterwards. Otherwise it is
+// a no-op.
+// Calling RYield at safe points of long read critical sections bounds the
+// latency of writers. The data protected by rw may be changed by the writer
+// across the call, thus the caller must not rely on anything read before.
+func (rw *RWMutex64) RYield() {
+	if rw.state.Load()&(rw64WriteIntent|rw64Upgrade) == 0 {
+		return
+	}
+	rw.RUnlock()
+	rw.RLock()
+}
+
+// UpgradeBlocking upgrades the read lock held by the caller to the write lock.
+// It blocks new readers from acquiring rw, waits until all other readers
+// released rw and then atomically turns the caller's read lock into the write
+// lock, without ever unlocking rw in between. Thus the upgrade completes as
+// long as the other readers eventually release rw, even if new readers keep
+// arriving. Afterwards rw must be unlocked with Unlock.
+//
+// Only a single reader may upgrade at a time: two upgrading readers would wait
+// for each other forever. UpgradeBlocking panics if another reader is already
+// upgrading rw; callers must ensure that at most one reader upgrades, e.g. by
+// holding a separate Mutex.
+// It is a run-time error if rw is not locked for reading on entry to
+// UpgradeBlocking.
+func (rw *RWMutex64) UpgradeBlocking() {
+	for {
+		state := rw.state.Load()
+		if state&rw64ReaderMask == 0 {
+			panic("spinlock: UpgradeBlocking of RWMutex64 not locked for reading")
+		}
+		if state&rw64Upgrade != 0 {
+			panic("spinlock: concurrent UpgradeBlocking of RWMutex64")
+		}
+		if rw.state.CompareAndSwap(state, state|rw64Upgrade) {
+			break
+		}
+	}
+
+	// Wait until the caller is the only reader left
+	spinUntil(func() bool {
+		state := rw.state.Load()
+		return state&rw64ReaderMask == rw64ReadOffset &&
+			rw.state.CompareAndSwap(state, state&^(rw64Upgrade|rw64ReaderMask)|rw64Write)
+	})
+	rw.dbg.releaseRead()
+	rw.dbg.acquiredWrite()
+}
+
+// Lock locks rw for writing.
+// If the lock is already locked for reading or writing,
+// Lock blocks until the lock is available.
+func (rw *RWMutex64) Lock() {
+	rw.dbg.acquireWrite()
+	if !rw.state.CompareAndSwap(0, rw64Write) {
+		rw.lockSlow()
+	}
+	rw.dbg.acquiredWrite()
+}
+
+func (rw *RWMutex64) lockSlow() {
+	recordContention()
+	rw.writers.Add(1)
+	defer rw.writers.Add(-1)
+	spinUntil(func() bool {
+		state := rw.state.Load()
+		if state&(rw64Write|rw64ReaderMask) != 0 {
+			// Keep new readers out until the lock is acquired
+			if state&rw64WriteIntent == 0 {
+				rw.state.Or(rw64WriteIntent)
+			}
+			return false
+		}
+		// Other waiting writers set the intent again
+		return rw.state.CompareAndSwap(state, state&^rw64WriteIntent|rw64Write)
+	})
+}
+
+// TryLock tries to lock rw for writing.
+// If the lock for writing can not be acquired immediately, false is returned.
+func (rw *RWMutex64) TryLock() bool {
+	state := rw.state.Load()
+	if state&(rw64Write|rw64ReaderMask) != 0 ||
+		!rw.state.CompareAndSwap(state, state|rw64Write) {
+		return false
+	}
+	rw.dbg.acquiredWrite()
+	return true
+}
+
+// Unlock unlocks rw for writing.  It is a run-time error if rw is
+// not locked for writing on entry to Unlock.
+//
+// As with Mutexes, a locked RWMutex64 is not associated with a particular
+// goroutine.  One goroutine may RLock (Lock) an RWMutex64 and then
+// arrange for another goroutine to RUnlock (Unlock) it.
+func (rw *RWMutex64) Unlock() {
+	rw.dbg.releaseWrite()
+
+	// Unset the Write bit
+	state := rw.state.Add(rw64WriterUnset)
+	if state&rw64Write != 0 {
+		panic("spinlock: Unlock of unlocked RWMutex64")
+	}
+}
+
+// WaitingWriters returns the number of goroutines waiting in Lock to acquire
+// rw for writing. The number is approximate: writers are counted shortly after
+// their first attempt to acquire the lock failed and until shortly after they
+// acquired it. It can guide backpressure decisions, e.g. to stop accepting
+// new writes while many writers are queued.
+func (rw *RWMutex64) WaitingWriters() int {
+	return int(rw.writers.Load())
+}
+
+// SetLevel assigns rw a level for detecting lock order violations, see
+// Mutex.SetLevel. Read and write locks of rw are both treated as holding rw.
+// SetLevel must be called before rw is used. It only has an effect in debug
+// builds (built with the spinlockdebug tag).
+func (rw *RWMutex64) SetLevel(level int) {
+	rw.dbg.setLevel(level)
+}
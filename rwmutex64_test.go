@@ -0,0 +1,318 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRWMutex64(t *testing.T) {
+	const iterations = 1000
+
+	var rw RWMutex64
+	hammerRWLocker(t, &rw, 4, iterations)
+	if state := rw.state.Load(); state != 0 {
+		t.Fatalf("state %#x after all locks were released, expected 0", state)
+	}
+}
+
+func TestRWMutex64ReaderCapacity(t *testing.T) {
+	var rw RWMutex64
+
+	// More readers than fit into the reader bits of RWMutex
+	const readers = math.MaxUint32 + 1
+	rw.state.Store((readers - 1) * rw64ReadOffset)
+	rw.RLock()
+	if n := rw.state.Load() / rw64ReadOffset; n != readers {
+		t.Fatalf("%d readers, expected %d", n, uint64(readers))
+	}
+	if rw.TryLock() {
+		t.Fatal("TryLock succeeded while read-locked")
+	}
+	if !rw.TryRLock() {
+		t.Fatal("TryRLock failed while read-locked")
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+	if n := rw.state.Load() / rw64ReadOffset; n != readers-1 {
+		t.Fatalf("%d readers after RUnlock, expected %d", n, uint64(readers-1))
+	}
+}
+
+func TestRWMutex64WriteIntent(t *testing.T) {
+	var rw RWMutex64
+
+	// A reader holds the lock when the writer arrives
+	rw.RLock()
+	wlocked := make(chan bool)
+	wunlock := make(chan bool)
+	go func() {
+		rw.Lock()
+		wlocked <- true
+		<-wunlock
+		rw.Unlock()
+	}()
+	for rw.state.Load()&rw64WriteIntent == 0 {
+		runtime.Gosched()
+	}
+
+	// Readers arriving after the writer must wait for it
+	if rw.TryRLock() {
+		t.Fatal("TryRLock succeeded while a writer is waiting")
+	}
+	rlocked := make(chan bool)
+	go func() {
+		rw.RLock()
+		rlocked <- true
+		rw.RUnlock()
+	}()
+	select {
+	case <-wlocked:
+		t.Fatal("writer did not wait for the present reader")
+	case <-rlocked:
+		t.Fatal("late reader did not wait for the waiting writer")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// The writer acquires the lock once the present reader left and clears
+	// its intent
+	rw.RUnlock()
+	<-wlocked
+	if state := rw.state.Load(); state != rw64Write {
+		t.Fatalf("state %#x while write-locked, expected %#x", state, rw64Write)
+	}
+	wunlock <- true
+	<-rlocked
+}
+
+func TestRWMutex64RYield(t *testing.T) {
+	var rw RWMutex64
+	rw.RLock()
+
+	// Without a waiting writer the read lock is retained
+	rw.RYield()
+	if rw.TryLock() {
+		t.Fatal("RYield released the read lock without a waiting writer")
+	}
+
+	written := false
+	done := make(chan bool)
+	go func() {
+		rw.Lock()
+		written = true
+		rw.Unlock()
+		done <- true
+	}()
+
+	// Long reader with yield points
+	for i := 0; !written; i++ {
+		if i > 1e6 {
+			t.Fatal("waiting writer did not get a turn")
+		}
+		rw.RYield()
+		runtime.Gosched()
+	}
+	// The reader resumed holding the read lock
+	if rw.TryLock() {
+		t.Fatal("read lock not held after RYield")
+	}
+	rw.RUnlock()
+	<-done
+
+	// Long reader with yield points and an upgrading reader
+	rw.RLock()
+	written = false
+	go func() {
+		rw.RLock()
+		rw.UpgradeBlocking()
+		written = true
+		rw.Unlock()
+		done <- true
+	}()
+	for i := 0; !written; i++ {
+		if i > 1e6 {
+			t.Fatal("upgrading reader did not get a turn")
+		}
+		rw.RYield()
+		runtime.Gosched()
+	}
+	rw.RUnlock()
+	<-done
+}
+
+func TestRWMutex64WaitingWriters(t *testing.T) {
+	const writers = 4
+
+	var rw RWMutex64
+	if n := rw.WaitingWriters(); n != 0 {
+		t.Fatalf("%d waiting writers on unlocked mutex", n)
+	}
+
+	// Block the writers behind a reader
+	rw.RLock()
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rw.Lock()
+			rw.Unlock()
+		}()
+	}
+	deadline := time.Now().Add(time.Second)
+	for rw.WaitingWriters() != writers {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d waiting writers, expected %d", rw.WaitingWriters(), writers)
+		}
+		runtime.Gosched()
+	}
+
+	rw.RUnlock()
+	wg.Wait()
+	if n := rw.WaitingWriters(); n != 0 {
+		t.Fatalf("%d waiting writers after all writers finished", n)
+	}
+}
+
+func TestRWMutex64UpgradeBlocking(t *testing.T) {
+	var rw RWMutex64
+
+	// Sole reader
+	rw.RLock()
+	rw.UpgradeBlocking()
+	if state := rw.state.Load(); state != rw64Write {
+		t.Fatalf("state %#x after upgrade, expected %#x", state, rw64Write)
+	}
+	rw.Unlock()
+
+	// Another reader holds rw while new readers keep arriving
+	rw.RLock()
+	other := make(chan bool)
+	go func() {
+		rw.RLock()
+		other <- true
+		<-other
+		rw.RUnlock()
+	}()
+	<-other
+
+	var stop int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				rw.RLock()
+				rw.RUnlock()
+			}
+		}()
+	}
+
+	upgraded := make(chan bool)
+	go func() {
+		rw.UpgradeBlocking()
+		upgraded <- true
+	}()
+	for rw.state.Load()&rw64Upgrade == 0 {
+		runtime.Gosched()
+	}
+	if rw.TryRLock() {
+		t.Fatal("new reader admitted during upgrade")
+	}
+	select {
+	case <-upgraded:
+		t.Fatal("upgrade did not wait for the other reader")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// The upgrade completes once the other reader drained
+	other <- true
+	<-upgraded
+	if rw.TryRLock() {
+		t.Fatal("read lock acquired after upgrade")
+	}
+	rw.Unlock()
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+	if state := rw.state.Load(); state != 0 {
+		t.Fatalf("state %#x after all locks were released, expected 0", state)
+	}
+}
+
+func TestRWMutex64UpgradeBlockingPanic(t *testing.T) {
+	var rw RWMutex64
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("UpgradeBlocking of unlocked RWMutex64 did not panic")
+			}
+		}()
+		rw.UpgradeBlocking()
+	}()
+
+	// Two upgrading readers
+	rw.RLock()
+	rw.RLock()
+	upgraded := make(chan bool)
+	go func() {
+		rw.UpgradeBlocking()
+		upgraded <- true
+	}()
+	for rw.state.Load()&rw64Upgrade == 0 {
+		runtime.Gosched()
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("concurrent UpgradeBlocking did not panic")
+			}
+		}()
+		rw.UpgradeBlocking()
+	}()
+	rw.RUnlock()
+	<-upgraded
+	rw.Unlock()
+	if state := rw.state.Load(); state != 0 {
+		t.Fatalf("state %#x after Unlock of the upgraded lock, expected 0", state)
+	}
+}
+
+func TestRWMutex64Panic(t *testing.T) {
+	for name, fn := range map[string]func(rw *RWMutex64){
+		"RUnlock": (*RWMutex64).RUnlock,
+		"Unlock":  (*RWMutex64).Unlock,
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%s of unlocked RWMutex64 did not panic", name)
+				}
+			}()
+			var rw RWMutex64
+			fn(&rw)
+		}()
+	}
+}
+
+func BenchmarkRWMutex64(b *testing.B) {
+	var rw RWMutex64
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if i%10 == 0 {
+				rw.Lock()
+				rw.Unlock()
+			} else {
+				rw.RLock()
+				rw.RUnlock()
+			}
+		}
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func parallelReader(m *RWMutex, clocked, cunlock, cdone chan bool) {
@@ -119,6 +120,45 @@ func HammerRWMutex(gomaxprocs, numReaders, iterations int) {
 	}
 }
 
+// An rwLocker is a reader/writer lock.
+type rwLocker interface {
+	sync.Locker
+	RLock()
+	RUnlock()
+}
+
+// hammerRWLocker runs 2 writers and the given number of readers, each locking
+// rw iterations times, and checks that writers exclude all other goroutines.
+func hammerRWLocker(t *testing.T, rw rwLocker, readers, iterations int) {
+	t.Helper()
+	var activity int32 // Number of active readers + 10000 * active writers
+	var wg sync.WaitGroup
+	for i := 0; i < 2+readers; i++ {
+		wg.Add(1)
+		go func(write bool) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				if write {
+					rw.Lock()
+					if n := atomic.AddInt32(&activity, 10000); n != 10000 {
+						t.Errorf("writer with activity %d", n)
+					}
+					atomic.AddInt32(&activity, -10000)
+					rw.Unlock()
+				} else {
+					rw.RLock()
+					if n := atomic.AddInt32(&activity, 1); n < 1 || n >= 10000 {
+						t.Errorf("reader with activity %d", n)
+					}
+					atomic.AddInt32(&activity, -1)
+					rw.RUnlock()
+				}
+			}
+		}(i < 2)
+	}
+	wg.Wait()
+}
+
 func TestRWMutex(t *testing.T) {
 	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(-1))
 	n := 1000
@@ -139,6 +179,119 @@ func TestRWMutex(t *testing.T) {
 	HammerRWMutex(10, 5, n)
 }
 
+func TestRWMutexReaderNotStarved(t *testing.T) {
+	const bound = time.Second
+
+	var rw RWMutex
+	var stop int32
+	var wg sync.WaitGroup
+	// Continuous stream of short writers
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				rw.Lock()
+				rw.Unlock()
+			}
+		}()
+	}
+	defer func() {
+		atomic.StoreInt32(&stop, 1)
+		wg.Wait()
+	}()
+
+	var longest time.Duration
+	for i := 0; i < 100; i++ {
+		start := time.Now()
+		rw.RLock()
+		if d := time.Since(start); d > longest {
+			longest = d
+		}
+		rw.RUnlock()
+	}
+	if longest > bound {
+		t.Fatalf("reader waited %v under continuous writes, expected at most %v", longest, bound)
+	}
+}
+
+func TestRLockNoYield(t *testing.T) {
+	defer SetSpinBudget(-1)
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	SetSpinBudget(1000)
+
+	var rw RWMutex
+	if !rw.RLockNoYield() {
+		t.Fatal("RLockNoYield failed while unlocked")
+	}
+	if !rw.RLockNoYield() {
+		t.Fatal("RLockNoYield failed while read-locked")
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+
+	// The writer can only release the lock if the reader yields
+	rw.Lock()
+	released := make(chan bool)
+	go func() {
+		rw.Unlock()
+		released <- true
+	}()
+	if rw.RLockNoYield() {
+		t.Fatal("RLockNoYield yielded to the writer")
+	}
+	<-released
+	if !rw.TryLock() {
+		t.Fatal("failed RLockNoYield did not undo the reader registration")
+	}
+	rw.Unlock()
+}
+
+func TestRWMutexTryUpgrade(t *testing.T) {
+	var rw RWMutex
+
+	// Sole reader
+	rw.RLock()
+	if !rw.TryUpgrade() {
+		t.Fatal("TryUpgrade of sole reader failed")
+	}
+	if rw.TryRLock() {
+		t.Fatal("read lock acquired after upgrade")
+	}
+	rw.Unlock()
+	if rw.state != rwmutexUnlocked {
+		t.Fatalf("state %#x after Unlock of upgraded lock, expected unlocked", rw.state)
+	}
+
+	// Multiple readers
+	rw.RLock()
+	rw.RLock()
+	if rw.TryUpgrade() {
+		t.Fatal("TryUpgrade succeeded with another reader")
+	}
+	if rw.TryLock() {
+		t.Fatal("read lock not retained after failed TryUpgrade")
+	}
+	rw.RUnlock()
+	if n := rw.state / rwmutexReadOffset; n != 1 {
+		t.Fatalf("%d readers after failed TryUpgrade and RUnlock, expected 1", n)
+	}
+	if !rw.TryUpgrade() {
+		t.Fatal("TryUpgrade failed after the other reader left")
+	}
+	rw.Unlock()
+
+	// Not read-locked
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("TryUpgrade of unlocked RWMutex did not panic")
+			}
+		}()
+		rw.TryUpgrade()
+	}()
+}
+
 func TestRLocker(t *testing.T) {
 	var wl RWMutex
 	var rl sync.Locker
@@ -174,6 +327,223 @@ func TestRLocker(t *testing.T) {
 	}
 }
 
+func TestRLockerCond(t *testing.T) {
+	var rw RWMutex
+	cond := sync.NewCond(rw.RLocker())
+	ready := false
+
+	const waiters = 4
+	woken := make(chan bool)
+	for i := 0; i < waiters; i++ {
+		go func() {
+			rw.RLock()
+			for !ready {
+				cond.Wait()
+			}
+			rw.RUnlock()
+			woken <- true
+		}()
+	}
+
+	// Waiting readers release the read lock, so a writer can get in
+	rw.Lock()
+	ready = true
+	rw.Unlock()
+	cond.Broadcast()
+	for i := 0; i < waiters; i++ {
+		<-woken
+	}
+	if !rw.TryLock() {
+		t.Fatal("read locks not released after wakeup")
+	}
+	rw.Unlock()
+}
+
+func TestTryRLockN(t *testing.T) {
+	var rw RWMutex
+	if !rw.TryRLockN(3) {
+		t.Fatal("TryRLockN failed")
+	}
+	if rw.TryLock() {
+		t.Fatal("TryLock succeeded while read-locked")
+	}
+	for i := 0; i < 3; i++ {
+		rw.RUnlock()
+	}
+	if !rw.TryLock() {
+		t.Fatal("TryLock failed after releasing all readers")
+	}
+	if rw.TryRLockN(5) {
+		t.Fatal("TryRLockN succeeded while write-locked")
+	}
+	if rw.state != rwmutexWrite {
+		t.Fatalf("TryRLockN did not roll back: state is %#x", rw.state)
+	}
+	rw.Unlock()
+}
+
+func TestTryRLockNPanic(t *testing.T) {
+	var rw RWMutex
+	for _, n := range []int{0, -1, rwmutexMaxReaders + 1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("TryRLockN(%d) did not panic", n)
+				}
+			}()
+			rw.TryRLockN(n)
+		}()
+		if rw.state != rwmutexUnlocked {
+			t.Fatalf("TryRLockN(%d) changed the state to %#x", n, rw.state)
+		}
+	}
+
+	// The readers of multiple calls exceed the capacity
+	rw.state = (rwmutexMaxReaders - 1) * rwmutexReadOffset
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("TryRLockN exceeding the reader capacity did not panic")
+			}
+		}()
+		rw.TryRLockN(2)
+	}()
+	if n := rw.state / rwmutexReadOffset; n != rwmutexMaxReaders-1 || rw.state&rwmutexWrite != 0 {
+		t.Fatalf("state %#x after overflowing TryRLockN, expected %d readers", rw.state, rwmutexMaxReaders-1)
+	}
+}
+
+func TestReadView(t *testing.T) {
+	var rw RWMutex
+	v := rw.ReadView()
+	locked := make(chan bool)
+	go func() {
+		rw.Lock()
+		locked <- true
+	}()
+	for i := 0; i < 10; i++ {
+		runtime.Gosched()
+		if !rw.TryRLock() {
+			t.Fatal("TryRLock failed while only a ReadView is open")
+		}
+		rw.RUnlock()
+		select {
+		case <-locked:
+			t.Fatal("write lock acquired while ReadView is open")
+		default:
+		}
+	}
+	v.Close()
+	<-locked
+	rw.Unlock()
+}
+
+func TestReadViewDoubleClose(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("double Close of ReadView did not panic")
+		}
+	}()
+	var rw RWMutex
+	v := rw.ReadView()
+	v.Close()
+	v.Close()
+}
+
+func TestWriteBatch(t *testing.T) {
+	var rw RWMutex
+	var activity int32
+	ops := make([]func(), 10)
+	for i := range ops {
+		ops[i] = func() {
+			if n := atomic.AddInt32(&activity, 10000); n != 10000 {
+				t.Errorf("write batch op with activity %d", n)
+			}
+			atomic.AddInt32(&activity, -10000)
+		}
+	}
+
+	cdone := make(chan bool)
+	go reader(&rw, 1000, &activity, cdone)
+	go func() {
+		for i := 0; i < 100; i++ {
+			rw.WriteBatch(ops)
+		}
+		cdone <- true
+	}()
+	<-cdone
+	<-cdone
+
+	// The lock is released if an op panics
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic in op not propagated")
+			}
+		}()
+		rw.WriteBatch([]func(){func() { panic("op") }})
+	}()
+	if !rw.TryLock() {
+		t.Fatal("lock not released after panic")
+	}
+}
+
+func TestWaitQuiescent(t *testing.T) {
+	var rw RWMutex
+	rw.WaitQuiescent() // unlocked
+
+	var stop int32
+	cdone := make(chan bool)
+	for i := 0; i < 3; i++ {
+		go func(write bool) {
+			for atomic.LoadInt32(&stop) == 0 {
+				if write {
+					rw.Lock()
+					runtime.Gosched()
+					rw.Unlock()
+				} else {
+					rw.RLock()
+					runtime.Gosched()
+					rw.RUnlock()
+				}
+				runtime.Gosched()
+			}
+			cdone <- true
+		}(i == 0)
+	}
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 10; i++ {
+			rw.WaitQuiescent()
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("WaitQuiescent did not return")
+	}
+	atomic.StoreInt32(&stop, 1)
+	for i := 0; i < 3; i++ {
+		<-cdone
+	}
+
+	// Held locks block WaitQuiescent
+	rw.RLock()
+	go func() {
+		rw.WaitQuiescent()
+		done <- true
+	}()
+	select {
+	case <-done:
+		t.Fatal("WaitQuiescent returned while read-locked")
+	case <-time.After(10 * time.Millisecond):
+	}
+	rw.RUnlock()
+	<-done
+}
+
 func TestUnlockPanic(t *testing.T) {
 	defer func() {
 		if recover() == nil {
@@ -319,3 +689,56 @@ func BenchmarkRWMutexWorkWrite10(b *testing.B) {
 func BenchmarkRWMutexWorkWrite1(b *testing.B) {
 	benchmarkRWMutex(b, 100, 1)
 }
+
+func BenchmarkRWMutexWriteIndividual(b *testing.B) {
+	var rw RWMutex
+	var counter int
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for i := 0; i < 10; i++ {
+				rw.Lock()
+				counter++
+				rw.Unlock()
+			}
+		}
+	})
+}
+
+func BenchmarkRWMutexWriteBatch(b *testing.B) {
+	var rw RWMutex
+	var counter int
+	b.RunParallel(func(pb *testing.PB) {
+		ops := make([]func(), 10)
+		for i := range ops {
+			ops[i] = func() { counter++ }
+		}
+		for pb.Next() {
+			rw.WriteBatch(ops)
+		}
+	})
+}
+
+// BenchmarkRWMutexReaderBurst measures a writer release followed by a burst
+// of readers waiting for it.
+func BenchmarkRWMutexReaderBurst(b *testing.B) {
+	const readers = 16
+
+	var rw RWMutex
+	var waiting, done sync.WaitGroup
+	for i := 0; i < b.N; i++ {
+		rw.Lock()
+		waiting.Add(readers)
+		done.Add(readers)
+		for j := 0; j < readers; j++ {
+			go func() {
+				waiting.Done()
+				rw.RLock()
+				rw.RUnlock()
+				done.Done()
+			}()
+		}
+		waiting.Wait()
+		rw.Unlock()
+		done.Wait()
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"hash/maphash"
+)
+
+// setShards is the number of shards of a ConcurrentSet. Must be a power of 2.
+const setShards = 32
+
+// setSeed is the hash seed shared by all ConcurrentSets.
+var setSeed = maphash.MakeSeed()
+
+// A ConcurrentSet is a set of values safe for concurrent use.
+// The set is sharded by the hash of its elements, each shard is guarded by its
+// own RWMutex. Contains only locks a shard for reading.
+// The zero value for a ConcurrentSet is an empty set.
+type ConcurrentSet[T comparable] struct {
+	shards [setShards]setShard[T]
+}
+
+type setShard[T comparable] struct {
+	mu RWMutex
+	m  map[T]struct{}
+	_  [64]byte // Avoid false sharing between shards
+}
+
+func (s *ConcurrentSet[T]) shard(v T) *setShard[T] {
+	return &s.shards[maphash.Comparable(setSeed, v)&(setShards-1)]
+}
+
+// Add adds v to the set.
+// It returns false if v was already in the set.
+func (s *ConcurrentSet[T]) Add(v T) bool {
+	sh := s.shard(v)
+	sh.mu.Lock()
+	_, exists := sh.m[v]
+	if !exists {
+		if sh.m == nil {
+			sh.m = make(map[T]struct{})
+		}
+		sh.m[v] = struct{}{}
+	}
+	sh.mu.Unlock()
+	return !exists
+}
+
+// Remove removes v from the set.
+// It returns false if v was not in the set.
+func (s *ConcurrentSet[T]) Remove(v T) bool {
+	sh := s.shard(v)
+	sh.mu.Lock()
+	_, exists := sh.m[v]
+	if exists {
+		delete(sh.m, v)
+	}
+	sh.mu.Unlock()
+	return exists
+}
+
+// Contains reports whether v is in the set.
+func (s *ConcurrentSet[T]) Contains(v T) bool {
+	sh := s.shard(v)
+	sh.mu.RLock()
+	_, exists := sh.m[v]
+	sh.mu.RUnlock()
+	return exists
+}
@@ -0,0 +1,112 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestConcurrentSet(t *testing.T) {
+	var s ConcurrentSet[string]
+	if s.Contains("a") {
+		t.Fatal("empty set contains a value")
+	}
+	if s.Remove("a") {
+		t.Fatal("Remove from empty set succeeded")
+	}
+	if !s.Add("a") {
+		t.Fatal("Add failed")
+	}
+	if s.Add("a") {
+		t.Fatal("Add of existing value succeeded")
+	}
+	if !s.Contains("a") {
+		t.Fatal("added value not contained")
+	}
+	if !s.Remove("a") {
+		t.Fatal("Remove failed")
+	}
+	if s.Contains("a") {
+		t.Fatal("removed value still contained")
+	}
+}
+
+func TestConcurrentSetConcurrent(t *testing.T) {
+	const goroutines = 8
+	const values = 1000
+
+	var s ConcurrentSet[int]
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			// Each goroutine owns the values v with v%goroutines == g
+			for v := g; v < values; v += goroutines {
+				if !s.Add(v) {
+					t.Errorf("Add(%d) failed", v)
+				}
+				if !s.Contains(v) {
+					t.Errorf("Contains(%d) failed after Add", v)
+				}
+				if v%2 == 0 && !s.Remove(v) {
+					t.Errorf("Remove(%d) failed", v)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for v := 0; v < values; v++ {
+		if s.Contains(v) != (v%2 == 1) {
+			t.Fatalf("Contains(%d) = %v", v, s.Contains(v))
+		}
+	}
+}
+
+// rwSet is a set guarded by a single RWMutex for comparison.
+type rwSet struct {
+	mu RWMutex
+	m  map[int]struct{}
+}
+
+func (s *rwSet) Contains(v int) bool {
+	s.mu.RLock()
+	_, exists := s.m[v]
+	s.mu.RUnlock()
+	return exists
+}
+
+func benchmarkSetContains(b *testing.B, contains func(int) bool, add func(int)) {
+	for v := 0; v < 1024; v += 2 {
+		add(v)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		v := 0
+		for pb.Next() {
+			if v%100 == 0 {
+				add(v & 1023)
+			} else {
+				contains(v & 1023)
+			}
+			v++
+		}
+	})
+}
+
+func BenchmarkConcurrentSetContains(b *testing.B) {
+	var s ConcurrentSet[int]
+	benchmarkSetContains(b, s.Contains, func(v int) { s.Add(v) })
+}
+
+func BenchmarkRWMutexSetContains(b *testing.B) {
+	s := rwSet{m: make(map[int]struct{})}
+	benchmarkSetContains(b, s.Contains, func(v int) {
+		s.mu.Lock()
+		s.m[v] = struct{}{}
+		s.mu.Unlock()
+	})
+}
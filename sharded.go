@@ -0,0 +1,57 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+// A ShardedMutex is a fixed set of independent Mutexes (shards), e.g. for
+// guarding the shards of a sharded map. Each shard is padded to avoid false
+// sharing. Callers select a shard by index, typically with ShardFor from a
+// hash they already computed.
+// A ShardedMutex must be created with NewShardedMutex.
+type ShardedMutex struct {
+	shards []paddedMutex
+}
+
+type paddedMutex struct {
+	Mutex
+	_ [60]byte // Avoid false sharing between shards
+}
+
+// NewShardedMutex returns a ShardedMutex with n shards.
+// It panics if n is not positive.
+func NewShardedMutex(n int) *ShardedMutex {
+	if n <= 0 {
+		panic("spinlock: non-positive number of shards")
+	}
+	return &ShardedMutex{shards: make([]paddedMutex, n)}
+}
+
+// Shards returns the number of shards of m.
+func (m *ShardedMutex) Shards() int {
+	return len(m.shards)
+}
+
+// ShardFor returns the shard index for key, which should be a well-distributed
+// hash.
+func (m *ShardedMutex) ShardFor(key uint64) int {
+	return int(key % uint64(len(m.shards)))
+}
+
+// Lock locks the given shard of m.
+// It panics if shard is out of range.
+func (m *ShardedMutex) Lock(shard int) {
+	m.shards[shard].Lock()
+}
+
+// TryLock tries to lock the given shard of m and reports whether it succeeded.
+// It panics if shard is out of range.
+func (m *ShardedMutex) TryLock(shard int) bool {
+	return m.shards[shard].TryLock()
+}
+
+// Unlock unlocks the given shard of m.
+// It is a run-time error if the shard is not locked on entry to Unlock.
+func (m *ShardedMutex) Unlock(shard int) {
+	m.shards[shard].Unlock()
+}
@@ -0,0 +1,91 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestShardedMutex(t *testing.T) {
+	const iterations = 1000
+
+	m := NewShardedMutex(4)
+	if n := m.Shards(); n != 4 {
+		t.Fatalf("%d shards, expected 4", n)
+	}
+	for key := uint64(0); key < 100; key++ {
+		if s := m.ShardFor(key); s < 0 || s >= 4 {
+			t.Fatalf("ShardFor(%d) = %d out of range", key, s)
+		}
+	}
+
+	// The same shard is mutually exclusive
+	var activity [4]int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(shard int) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				m.Lock(shard)
+				if n := atomic.AddInt32(&activity[shard], 1); n != 1 {
+					t.Errorf("%d goroutines in critical section of shard %d", n, shard)
+				}
+				atomic.AddInt32(&activity[shard], -1)
+				m.Unlock(shard)
+			}
+		}(i % 4)
+	}
+	wg.Wait()
+
+	// Different shards proceed concurrently
+	m.Lock(0)
+	if m.TryLock(0) {
+		t.Fatal("TryLock of locked shard succeeded")
+	}
+	done := make(chan bool)
+	go func() {
+		m.Lock(1)
+		m.Unlock(1)
+		done <- true
+	}()
+	<-done
+	m.Unlock(0)
+}
+
+func TestShardedMutexPanic(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("non-positive number of shards did not panic")
+		}
+	}()
+	NewShardedMutex(0)
+}
+
+func BenchmarkShardedMutex(b *testing.B) {
+	m := NewShardedMutex(64)
+	var key uint64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			shard := m.ShardFor(atomic.AddUint64(&key, 1))
+			m.Lock(shard)
+			m.Unlock(shard)
+		}
+	})
+}
+
+func BenchmarkShardedMutexSingle(b *testing.B) {
+	var m Mutex
+	var key uint64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			atomic.AddUint64(&key, 1)
+			m.Lock()
+			m.Unlock()
+		}
+	})
+}
@@ -0,0 +1,59 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"bytes"
+	"os"
+	"sync/atomic"
+)
+
+const (
+	// smtPauseCycles is the number of PAUSE instructions between two
+	// attempts to acquire a lock by busy waiting in SMT mode.
+	smtPauseCycles = 30
+
+	// smtBudgetDivisor is the factor by which the number of attempts is
+	// reduced in SMT mode.
+	smtBudgetDivisor = 4
+)
+
+// smtMode is 1 if SMT mode is enabled.
+var smtMode int32
+
+func init() {
+	if detectSMT() {
+		smtMode = 1
+	}
+}
+
+// detectSMT reports whether simultaneous multithreading (hyper-threading) is
+// active. Detection is best-effort: it is only supported on Linux, on other
+// systems false is reported.
+func detectSMT() bool {
+	b, err := os.ReadFile("/sys/devices/system/cpu/smt/active")
+	return err == nil && bytes.Equal(bytes.TrimSpace(b), []byte("1"))
+}
+
+// SMTMode reports whether SMT mode is enabled.
+// It is enabled by default if simultaneous multithreading (hyper-threading)
+// was detected at program initialization, which is currently only supported on
+// Linux.
+func SMTMode() bool {
+	return atomic.LoadInt32(&smtMode) != 0
+}
+
+// SetSMTMode enables or disables SMT mode.
+// On SMT systems a busy waiting goroutine steals execution resources from the
+// sibling logical CPU, which might run the goroutine holding the lock. In SMT
+// mode, lock operations busy wait with fewer attempts to acquire the lock and
+// longer pauses between the attempts, leaving more resources to the sibling.
+func SetSMTMode(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&smtMode, v)
+}
@@ -0,0 +1,59 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestSMTMode(t *testing.T) {
+	defer SetSMTMode(SMTMode())
+	defer SetSpinBudget(-1) // After restoring GOMAXPROCS
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	SetSpinBudget(64)
+
+	SetSMTMode(true)
+	if !SMTMode() {
+		t.Fatal("SMT mode not enabled")
+	}
+	if n := spinAttempts(); n != 64/smtBudgetDivisor {
+		t.Fatalf("%d spin attempts in SMT mode, expected %d", n, 64/smtBudgetDivisor)
+	}
+	c := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go HammerMutex(new(Mutex), 1000, c)
+	}
+	m := new(Mutex)
+	for i := 0; i < 4; i++ {
+		go HammerMutex(m, 1000, c)
+	}
+	HammerRWMutex(4, 4, 1000)
+	for i := 0; i < 8; i++ {
+		<-c
+	}
+
+	SetSMTMode(false)
+	if SMTMode() {
+		t.Fatal("SMT mode not disabled")
+	}
+	if n := spinAttempts(); n != 64 {
+		t.Fatalf("%d spin attempts without SMT mode, expected 64", n)
+	}
+}
+
+func benchmarkMutexSMTMode(b *testing.B, enabled bool) {
+	defer SetSMTMode(SMTMode())
+	SetSMTMode(enabled)
+	benchmarkMutex(b, true, true)
+}
+
+func BenchmarkMutexSMTModeOff(b *testing.B) {
+	benchmarkMutexSMTMode(b, false)
+}
+
+func BenchmarkMutexSMTModeOn(b *testing.B) {
+	benchmarkMutexSMTMode(b, true)
+}
@@ -0,0 +1,146 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	spinPerCPU    = 16  // Spin budget for each additional CPU
+	maxSpinBudget = 256 // Upper bound of the default spin budget
+)
+
+var (
+	spinBudget    = int32(DefaultSpinBudget())
+	spinBudgetSet int32 // 1 if the budget was overridden by SetSpinBudget
+
+	spinBudgetMu    sync.Mutex
+	spinBudgetGen   uint64      // Invalidates pending refreshes
+	spinBudgetTimer *time.Timer // Refreshes the default budget
+)
+
+func init() {
+	spinBudgetMu.Lock()
+	scheduleSpinBudgetRefresh()
+	spinBudgetMu.Unlock()
+}
+
+// spinBudgetFor returns the default spin budget for the given number of CPUs.
+// Spinning only pays off if the lock holder can run in parallel, thus the
+// budget is 0 for a single CPU and grows with each additional CPU, up to
+// maxSpinBudget.
+func spinBudgetFor(procs int) int {
+	if procs <= 1 {
+		return 0
+	}
+	if budget := spinPerCPU * (procs - 1); budget < maxSpinBudget {
+		return budget
+	}
+	return maxSpinBudget
+}
+
+// DefaultSpinBudget returns the spin budget computed from EffectiveCPUs.
+// It is 0 if only a single CPU is effectively available, and 16 attempts for
+// each additional CPU, but at most 256.
+func DefaultSpinBudget() int {
+	return spinBudgetFor(EffectiveCPUs())
+}
+
+// SpinBudget returns the number of times a contended lock operation retries to
+// acquire the lock by busy waiting before it yields the processor.
+// Unless it was overridden by SetSpinBudget, it is the DefaultSpinBudget,
+// which is recomputed in the background once per second to follow changes of
+// GOMAXPROCS and the CPU quota.
+func SpinBudget() int {
+	return int(atomic.LoadInt32(&spinBudget))
+}
+
+// scheduleSpinBudgetRefresh schedules the next recomputation of the default
+// spin budget, cancelling a pending one. spinBudgetMu must be held.
+func scheduleSpinBudgetRefresh() {
+	cancelSpinBudgetRefresh()
+	gen := spinBudgetGen
+	spinBudgetTimer = time.AfterFunc(effectiveCPUsRefresh, func() {
+		refreshSpinBudget(gen)
+	})
+}
+
+// cancelSpinBudgetRefresh cancels a pending recomputation of the default spin
+// budget. spinBudgetMu must be held.
+func cancelSpinBudgetRefresh() {
+	spinBudgetGen++
+	if spinBudgetTimer != nil {
+		spinBudgetTimer.Stop()
+		spinBudgetTimer = nil
+	}
+}
+
+// refreshSpinBudget recomputes the default spin budget and schedules the next
+// recomputation, unless the refresh gen was cancelled or the budget is
+// overridden.
+func refreshSpinBudget(gen uint64) {
+	budget := DefaultSpinBudget()
+	spinBudgetMu.Lock()
+	if gen == spinBudgetGen && atomic.LoadInt32(&spinBudgetSet) == 0 {
+		atomic.StoreInt32(&spinBudget, int32(budget))
+		scheduleSpinBudgetRefresh()
+	}
+	spinBudgetMu.Unlock()
+}
+
+// SetSpinBudget overrides the spin budget.
+// A negative budget restores the default spin budget, which again follows
+// changes of GOMAXPROCS and the CPU quota.
+func SetSpinBudget(budget int) {
+	spinBudgetMu.Lock()
+	if budget < 0 {
+		budget = DefaultSpinBudget()
+		atomic.StoreInt32(&spinBudgetSet, 0)
+		scheduleSpinBudgetRefresh()
+	} else {
+		atomic.StoreInt32(&spinBudgetSet, 1)
+		cancelSpinBudgetRefresh()
+	}
+	atomic.StoreInt32(&spinBudget, int32(budget))
+	spinBudgetMu.Unlock()
+}
+
+// spinAttempts returns the number of attempts to acquire a contended lock by
+// busy waiting before the processor is yielded.
+func spinAttempts() int {
+	budget := SpinBudget()
+	if atomic.LoadInt32(&smtMode) != 0 {
+		budget /= smtBudgetDivisor
+	}
+	return budget
+}
+
+// spinPause is called between attempts to acquire a contended lock by busy
+// waiting.
+func spinPause() {
+	if atomic.LoadInt32(&smtMode) != 0 {
+		procyield(smtPauseCycles)
+	}
+}
+
+// spinUntil busy waits until cond returns true.
+// The processor is yielded after each spinAttempts failed checks.
+func spinUntil(cond func() bool) {
+	for {
+		for i := spinAttempts(); i > 0; i-- {
+			if cond() {
+				return
+			}
+			spinPause()
+		}
+		yield()
+		if cond() {
+			return
+		}
+	}
+}
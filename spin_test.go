@@ -0,0 +1,52 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"testing"
+)
+
+func TestSpinBudgetFor(t *testing.T) {
+	if budget := spinBudgetFor(1); budget != 0 {
+		t.Fatalf("spin budget for a single CPU is %d, expected 0", budget)
+	}
+	prev := 0
+	for procs := 2; procs <= 64; procs++ {
+		budget := spinBudgetFor(procs)
+		if budget < prev {
+			t.Fatalf("spin budget for %d CPUs (%d) is lower than for %d CPUs (%d)",
+				procs, budget, procs-1, prev)
+		}
+		if budget <= 0 || budget > maxSpinBudget {
+			t.Fatalf("spin budget for %d CPUs (%d) out of bounds", procs, budget)
+		}
+		prev = budget
+	}
+	if budget := spinBudgetFor(1024); budget != maxSpinBudget {
+		t.Fatalf("spin budget for 1024 CPUs is %d, expected %d", budget, maxSpinBudget)
+	}
+}
+
+func TestSpinBudget(t *testing.T) {
+	defer SetSpinBudget(-1)
+
+	if budget := DefaultSpinBudget(); budget < 0 || budget > maxSpinBudget {
+		t.Fatalf("default spin budget %d out of bounds", budget)
+	}
+	if SpinBudget() != DefaultSpinBudget() {
+		t.Fatalf("spin budget %d is not the default %d", SpinBudget(), DefaultSpinBudget())
+	}
+
+	SetSpinBudget(1000)
+	if budget := SpinBudget(); budget != 1000 {
+		t.Fatalf("spin budget is %d after override, expected 1000", budget)
+	}
+	HammerMutex(new(Mutex), 1000, make(chan bool, 1))
+
+	SetSpinBudget(-1)
+	if SpinBudget() != DefaultSpinBudget() {
+		t.Fatalf("spin budget %d is not the default %d after reset", SpinBudget(), DefaultSpinBudget())
+	}
+}
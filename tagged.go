@@ -0,0 +1,102 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync/atomic"
+)
+
+const (
+	taggedLocked   = 1  // Bit 1 is used as a flag for the locked state
+	taggedTagShift = 32 // Bits 33-64 store the tag, bits 2-32 are unused
+)
+
+// A TaggedMutex is a mutual exclusion lock whose state word additionally
+// holds an application-defined 32-bit tag. LockWithTag atomically acquires the
+// lock only if the tag has an expected value, which allows protocols where the
+// lock may only be taken in a particular application state, e.g. "only lock if
+// not yet finalized". The tag can only be changed by the holder of the lock.
+// The zero value for a TaggedMutex is an unlocked mutex with tag 0.
+type TaggedMutex struct {
+	dbg   mutexDebug
+	state atomic.Uint64
+}
+
+// Lock locks m, independent of its tag.
+// If the lock is already in use, the calling goroutine waits until the lock is
+// available.
+func (m *TaggedMutex) Lock() {
+	m.dbg.acquire()
+	if !m.tryLock() {
+		spinUntil(m.tryLock)
+	}
+	m.dbg.acquired()
+}
+
+func (m *TaggedMutex) tryLock() bool {
+	state := m.state.Load()
+	return state&taggedLocked == 0 && m.state.CompareAndSwap(state, state|taggedLocked)
+}
+
+// TryLock tries to lock m, independent of its tag.
+// If the lock is already in use, false is returned.
+func (m *TaggedMutex) TryLock() bool {
+	if !m.tryLock() {
+		return false
+	}
+	m.dbg.acquired()
+	return true
+}
+
+// LockWithTag locks m if its tag equals tag. If the lock is in use, the
+// calling goroutine waits until the lock is available, as the holder may
+// change the tag. It returns false without acquiring the lock as soon as the
+// tag is observed to differ from tag.
+func (m *TaggedMutex) LockWithTag(tag uint32) bool {
+	want := uint64(tag) << taggedTagShift
+	m.dbg.acquire()
+	if !m.state.CompareAndSwap(want, want|taggedLocked) {
+		acquired := false
+		spinUntil(func() bool {
+			state := m.state.Load()
+			if state&^taggedLocked != want {
+				return true // Tag differs
+			}
+			acquired = state&taggedLocked == 0 && m.state.CompareAndSwap(state, state|taggedLocked)
+			return acquired
+		})
+		if !acquired {
+			return false
+		}
+	}
+	m.dbg.acquired()
+	return true
+}
+
+// Tag returns the current tag of m.
+func (m *TaggedMutex) Tag() uint32 {
+	return uint32(m.state.Load() >> taggedTagShift)
+}
+
+// SetTag sets the tag of m. The caller must hold the lock.
+// It is a run-time error if m is not locked on entry to SetTag.
+func (m *TaggedMutex) SetTag(tag uint32) {
+	if m.state.Load()&taggedLocked == 0 {
+		panic("spinlock: SetTag of unlocked TaggedMutex")
+	}
+	// Other goroutines do not modify the state while it is locked
+	m.state.Store(uint64(tag)<<taggedTagShift | taggedLocked)
+}
+
+// Unlock unlocks m, retaining its tag.
+// It is a run-time error if m is not locked on entry to Unlock.
+func (m *TaggedMutex) Unlock() {
+	m.dbg.release()
+	state := m.state.Load()
+	if state&taggedLocked == 0 {
+		panic("spinlock: unlock of unlocked TaggedMutex")
+	}
+	m.state.Store(state &^ taggedLocked)
+}
@@ -0,0 +1,99 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTaggedMutex(t *testing.T) {
+	const (
+		open = iota
+		finalized
+	)
+
+	var m TaggedMutex
+	if !m.LockWithTag(open) {
+		t.Fatal("LockWithTag with matching tag failed")
+	}
+	if m.TryLock() {
+		t.Fatal("TryLock succeeded while locked")
+	}
+	m.SetTag(finalized)
+	m.Unlock()
+	if tag := m.Tag(); tag != finalized {
+		t.Fatalf("tag is %d after Unlock, expected %d", tag, finalized)
+	}
+
+	// Rejection when the tag differs
+	if m.LockWithTag(open) {
+		t.Fatal("LockWithTag with differing tag succeeded")
+	}
+	if !m.TryLock() {
+		t.Fatal("rejected LockWithTag acquired the lock")
+	}
+	m.Unlock()
+
+	// A waiting LockWithTag observes the tag set by the holder
+	m.Lock()
+	result := make(chan bool)
+	go func() { result <- m.LockWithTag(finalized) }()
+	time.Sleep(time.Millisecond)
+	m.SetTag(open)
+	m.Unlock()
+	if <-result {
+		t.Fatal("LockWithTag succeeded after the holder changed the tag")
+	}
+}
+
+func TestTaggedMutexConcurrent(t *testing.T) {
+	const iterations = 1000
+
+	// Goroutines only lock if the tag is their own, then pass the lock on
+	// to the next goroutine by setting its tag
+	var m TaggedMutex
+	var turns int32
+	lockers := make([]sync.Locker, 4)
+	for i := range lockers {
+		g := uint32(i)
+		lockers[i] = funcLocker{
+			func() {
+				for !m.LockWithTag(g) {
+					runtime.Gosched() // Not our turn
+				}
+			},
+			func() {
+				atomic.AddInt32(&turns, 1)
+				m.SetTag((g + 1) % 4)
+				m.Unlock()
+			},
+		}
+	}
+	hammerLocker(t, iterations, lockers...)
+	if turns != 4*iterations {
+		t.Fatalf("%d turns, expected %d", turns, 4*iterations)
+	}
+}
+
+func TestTaggedMutexPanic(t *testing.T) {
+	for name, fn := range map[string]func(m *TaggedMutex){
+		"SetTag": func(m *TaggedMutex) { m.SetTag(1) },
+		"Unlock": (*TaggedMutex).Unlock,
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%s of unlocked TaggedMutex did not panic", name)
+				}
+			}()
+			var m TaggedMutex
+			fn(&m)
+		}()
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"time"
+)
+
+// A TokenBucket is a rate limiter. Tokens are added to the bucket at a fixed
+// rate, up to the burst size, and each event consumes one token.
+// A TokenBucket must be created with NewTokenBucket.
+type TokenBucket struct {
+	mu     Mutex
+	tokens float64   // May be negative due to reservations
+	last   time.Time // Time of the last refill
+
+	rate  float64 // Tokens per second
+	burst float64
+	now   func() time.Time
+}
+
+// NewTokenBucket returns a full TokenBucket which is refilled with rate tokens
+// per second and holds at most burst tokens.
+// It panics if rate or burst is not positive.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if !(rate > 0) || burst <= 0 {
+		panic("spinlock: non-positive TokenBucket rate or burst")
+	}
+	return newTokenBucket(rate, burst, time.Now)
+}
+
+func newTokenBucket(rate float64, burst int, now func() time.Time) *TokenBucket {
+	return &TokenBucket{
+		tokens: float64(burst),
+		last:   now(),
+		rate:   rate,
+		burst:  float64(burst),
+		now:    now,
+	}
+}
+
+// refill adds the tokens accumulated since the last refill.
+// b.mu must be held.
+func (b *TokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		if b.tokens += elapsed.Seconds() * b.rate; b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// checkN panics if n events can never fit into the bucket.
+func (b *TokenBucket) checkN(n int) {
+	if n <= 0 {
+		panic("spinlock: non-positive TokenBucket event count")
+	}
+	if float64(n) > b.burst {
+		panic("spinlock: TokenBucket event count exceeds the burst size")
+	}
+}
+
+// Allow reports whether an event may happen now and consumes a token if so.
+// It is shorthand for AllowN(1).
+func (b *TokenBucket) Allow() bool {
+	return b.AllowN(1)
+}
+
+// AllowN reports whether n events may happen now and consumes n tokens if so.
+// Otherwise no tokens are consumed.
+// It panics if n is not positive or exceeds the burst size, since such a
+// request could never be allowed.
+func (b *TokenBucket) AllowN(n int) bool {
+	b.checkN(n)
+	now := b.now()
+	b.mu.Lock()
+	b.refill(now)
+	ok := b.tokens >= float64(n)
+	if ok {
+		b.tokens -= float64(n)
+	}
+	b.mu.Unlock()
+	return ok
+}
+
+// Reserve consumes n tokens unconditionally and returns how long the caller
+// must wait before the n events may happen. The reserved tokens are not
+// available to other callers, which have to wait accordingly longer.
+// It panics if n is not positive or exceeds the burst size.
+func (b *TokenBucket) Reserve(n int) time.Duration {
+	b.checkN(n)
+	now := b.now()
+	b.mu.Lock()
+	b.refill(now)
+	b.tokens -= float64(n)
+	deficit := -b.tokens
+	b.mu.Unlock()
+	if deficit <= 0 {
+		return 0
+	}
+	return time.Duration(deficit / b.rate * float64(time.Second))
+}
@@ -0,0 +1,142 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestTokenBucketExhaustion(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	b := newTokenBucket(10, 5, clock.Now)
+	for i := 0; i < 5; i++ {
+		if !b.Allow() {
+			t.Fatalf("Allow %d of full bucket failed", i)
+		}
+	}
+	if b.Allow() {
+		t.Fatal("Allow of exhausted bucket succeeded")
+	}
+
+	clock.Advance(time.Second)
+	if !b.AllowN(5) {
+		t.Fatal("AllowN of refilled bucket failed")
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	b := newTokenBucket(10, 5, clock.Now)
+	if !b.AllowN(5) {
+		t.Fatal("AllowN of full bucket failed")
+	}
+
+	clock.Advance(250 * time.Millisecond)
+	if !b.AllowN(2) {
+		t.Fatal("AllowN failed after refilling 2.5 tokens")
+	}
+	if b.Allow() {
+		t.Fatal("Allow succeeded with 0.5 tokens")
+	}
+	clock.Advance(50 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("Allow failed after refilling to 1 token")
+	}
+
+	// The bucket holds at most burst tokens
+	clock.Advance(time.Hour)
+	if !b.AllowN(5) || b.Allow() {
+		t.Fatal("bucket refilled beyond the burst")
+	}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	b := newTokenBucket(10, 5, clock.Now)
+	if d := b.Reserve(5); d != 0 {
+		t.Fatalf("Reserve of full bucket returned %v, expected 0", d)
+	}
+	if d := b.Reserve(2); d != 200*time.Millisecond {
+		t.Fatalf("Reserve returned %v, expected %v", d, 200*time.Millisecond)
+	}
+	if d := b.Reserve(1); d != 300*time.Millisecond {
+		t.Fatalf("second Reserve returned %v, expected %v", d, 300*time.Millisecond)
+	}
+	if b.Allow() {
+		t.Fatal("Allow succeeded while tokens are reserved")
+	}
+	clock.Advance(400 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("Allow failed after the reservations were refilled")
+	}
+}
+
+func TestTokenBucketPanic(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("non-positive rate did not panic")
+		}
+	}()
+	NewTokenBucket(0, 1)
+}
+
+func TestTokenBucketInvalidN(t *testing.T) {
+	b := NewTokenBucket(10, 5)
+	for _, n := range []int{0, -1, 6} {
+		for name, f := range map[string]func(){
+			"AllowN":  func() { b.AllowN(n) },
+			"Reserve": func() { b.Reserve(n) },
+		} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("%s(%d) did not panic", name, n)
+					}
+				}()
+				f()
+			}()
+		}
+	}
+
+	// The rejected calls must not have changed the tokens
+	if !b.AllowN(5) {
+		t.Fatal("AllowN of the burst size failed after invalid calls")
+	}
+}
+
+func TestTokenBucketConcurrent(t *testing.T) {
+	const burst = 1000
+	// A negligible rate, so that practically no tokens are refilled
+	b := NewTokenBucket(1e-9, burst)
+
+	var allowed int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < burst/2; j++ {
+				if b.Allow() {
+					atomic.AddInt64(&allowed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != burst {
+		t.Fatalf("%d events allowed, expected %d", allowed, burst)
+	}
+}
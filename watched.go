@@ -0,0 +1,74 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"time"
+)
+
+// A WatchedRWMutex is a reader/writer mutual exclusion lock, like RWMutex,
+// which reports writers holding the lock for longer than a configured limit.
+// The lock is never released forcibly, the problem is only surfaced.
+// The zero value for a WatchedRWMutex is an unlocked mutex without a limit.
+type WatchedRWMutex struct {
+	rw      RWMutex
+	limit   time.Duration
+	onLimit func(LongHold)
+	hold    holdWatch
+}
+
+// SetHoldLimit sets the maximum duration a writer may hold rw.
+// If a writer still holds rw after limit, fn is called from a separate
+// goroutine while the writer holds the lock, with the stack of the goroutine
+// that acquired the write lock. The writer is reported even if it never
+// releases rw.
+// A limit <= 0 or a nil fn disables the check.
+//
+// SetHoldLimit must not be called concurrently with other methods of rw.
+func (rw *WatchedRWMutex) SetHoldLimit(limit time.Duration, fn func(LongHold)) {
+	if limit <= 0 {
+		fn = nil
+	}
+	rw.limit = limit
+	rw.onLimit = fn
+}
+
+// RLock locks rw for reading. Readers are not watched.
+func (rw *WatchedRWMutex) RLock() {
+	rw.rw.RLock()
+}
+
+// TryRLock tries to lock rw for reading.
+// If a lock for reading can not be acquired immediately, false is returned.
+func (rw *WatchedRWMutex) TryRLock() bool {
+	return rw.rw.TryRLock()
+}
+
+// RUnlock undoes a single RLock call.
+func (rw *WatchedRWMutex) RUnlock() {
+	rw.rw.RUnlock()
+}
+
+// Lock locks rw for writing and starts watching the hold time.
+func (rw *WatchedRWMutex) Lock() {
+	rw.rw.Lock()
+	rw.hold.start(rw.limit, rw.onLimit)
+}
+
+// TryLock tries to lock rw for writing and starts watching the hold time on
+// success.
+func (rw *WatchedRWMutex) TryLock() bool {
+	if !rw.rw.TryLock() {
+		return false
+	}
+	rw.hold.start(rw.limit, rw.onLimit)
+	return true
+}
+
+// Unlock unlocks rw for writing.
+func (rw *WatchedRWMutex) Unlock() {
+	rw.hold.stop()
+	rw.rw.Unlock()
+}
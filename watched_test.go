@@ -0,0 +1,118 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchedRWMutexHoldLimit(t *testing.T) {
+	const limit = 10 * time.Millisecond
+
+	var rw WatchedRWMutex
+	reported := make(chan LongHold, 10)
+	rw.SetHoldLimit(limit, func(h LongHold) {
+		if rw.TryRLock() {
+			rw.RUnlock()
+			t.Error("lock not held when the callback was called")
+		}
+		reported <- h
+	})
+
+	// Short critical sections must not be reported
+	rw.Lock()
+	rw.Unlock()
+	time.Sleep(2 * limit)
+	select {
+	case h := <-reported:
+		t.Fatalf("short hold reported: %v", h.Held)
+	default:
+	}
+
+	// A stuck writer is reported while it still holds the lock
+	rw.Lock()
+	var h LongHold
+	select {
+	case h = <-reported:
+	case <-time.After(time.Second):
+		t.Fatal("stuck writer not reported")
+	}
+	rw.Unlock()
+	if h.Held < limit {
+		t.Fatalf("reported hold duration %v, expected at least %v", h.Held, limit)
+	}
+	if !strings.Contains(h.Stack, "TestWatchedRWMutexHoldLimit") {
+		t.Fatalf("stack does not show the holder:\n%s", h.Stack)
+	}
+
+	// Write locks acquired by TryLock are watched as well
+	if !rw.TryLock() {
+		t.Fatal("TryLock failed")
+	}
+	select {
+	case <-reported:
+	case <-time.After(time.Second):
+		t.Fatal("stuck writer acquired by TryLock not reported")
+	}
+	rw.Unlock()
+
+	// Readers are not watched
+	rw.RLock()
+	time.Sleep(2 * limit)
+	rw.RUnlock()
+	select {
+	case <-reported:
+		t.Fatal("read lock reported")
+	default:
+	}
+}
+
+func TestWatchedRWMutexDisabled(t *testing.T) {
+	var rw WatchedRWMutex
+	rw.Lock()
+	time.Sleep(time.Millisecond)
+	rw.Unlock()
+
+	rw.SetHoldLimit(time.Nanosecond, nil)
+	rw.Lock()
+	time.Sleep(time.Millisecond)
+	rw.Unlock()
+
+	rw.SetHoldLimit(0, func(LongHold) { t.Error("disabled hold limit reported") })
+	rw.Lock()
+	time.Sleep(time.Millisecond)
+	rw.Unlock()
+}
+
+func TestWatchedRWMutexUnlockDuringPanic(t *testing.T) {
+	const limit = time.Millisecond
+
+	var rw WatchedRWMutex
+	reported := make(chan LongHold, 10)
+	rw.SetHoldLimit(limit, func(h LongHold) { reported <- h })
+
+	func() {
+		defer func() {
+			if r := recover(); r != "critical section" {
+				t.Fatalf("original panic was masked: %v", r)
+			}
+		}()
+		rw.Lock()
+		defer rw.Unlock()
+		<-reported
+		panic("critical section")
+	}()
+
+	if !rw.TryLock() {
+		t.Fatal("lock not released by Unlock during panic")
+	}
+	rw.Unlock()
+	time.Sleep(2 * limit)
+	if len(reported) != 0 {
+		t.Fatal("hold reported after Unlock during panic")
+	}
+}
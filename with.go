@@ -0,0 +1,78 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// With2 locks a and b, calls fn and unlocks both again, even if fn panics.
+// The locks are always acquired in the same order, independent of the order of
+// the arguments, so concurrent calls with swapped arguments can not deadlock.
+// a and b may be the same Mutex.
+func With2(a, b *Mutex, fn func()) {
+	if a == b {
+		a.Lock()
+		defer a.Unlock()
+		fn()
+		return
+	}
+	with2(a, b, unsafe.Pointer(a), unsafe.Pointer(b), fn)
+}
+
+// With2Write locks a and b for writing, calls fn and unlocks both again, even
+// if fn panics. The locks are acquired in the same order as by With2.
+// a and b may be the same RWMutex.
+func With2Write(a, b *RWMutex, fn func()) {
+	if a == b {
+		a.Lock()
+		defer a.Unlock()
+		fn()
+		return
+	}
+	with2(a, b, unsafe.Pointer(a), unsafe.Pointer(b), fn)
+}
+
+// With2ReadWrite locks r for reading and w for writing, calls fn and unlocks
+// both again, even if fn panics. The locks are acquired in the same order as by
+// With2, independent of which one is locked for reading.
+// It panics if r and w are the same RWMutex.
+func With2ReadWrite(r, w *RWMutex, fn func()) {
+	if r == w {
+		panic("spinlock: With2ReadWrite with identical locks")
+	}
+	with2(r.RLocker(), w, unsafe.Pointer(r), unsafe.Pointer(w), fn)
+}
+
+// ReadValue locks rw for reading, calls read and unlocks rw again, even if
+// read panics. It returns the value returned by read:
+//
+//	n := ReadValue(&rw, func() int { return len(m) })
+func ReadValue[T any](rw *RWMutex, read func() T) T {
+	rw.RLock()
+	defer rw.RUnlock()
+	return read()
+}
+
+// WriteValue locks rw for writing, calls write and unlocks rw again, even if
+// write panics. It returns the value returned by write.
+func WriteValue[T any](rw *RWMutex, write func() T) T {
+	rw.Lock()
+	defer rw.Unlock()
+	return write()
+}
+
+// with2 acquires a and b in canonical order of their addresses pa and pb.
+func with2(a, b sync.Locker, pa, pb unsafe.Pointer, fn func()) {
+	if uintptr(pa) > uintptr(pb) {
+		a, b = b, a
+	}
+	a.Lock()
+	defer a.Unlock()
+	b.Lock()
+	defer b.Unlock()
+	fn()
+}
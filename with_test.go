@@ -0,0 +1,175 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spinlock
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestWith2(t *testing.T) {
+	const iterations = 1000
+
+	var a, b Mutex
+	var wg sync.WaitGroup
+	counter := 0
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(swap bool) {
+			defer wg.Done()
+			x, y := &a, &b
+			if swap {
+				x, y = y, x
+			}
+			for j := 0; j < iterations; j++ {
+				With2(x, y, func() { counter++ })
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+
+	if counter != 4*iterations {
+		t.Fatalf("counter is %d, expected %d", counter, 4*iterations)
+	}
+	if !a.TryLock() || !b.TryLock() {
+		t.Fatal("locks not released")
+	}
+
+	// Identical locks must not deadlock
+	var c Mutex
+	With2(&c, &c, func() {
+		if c.TryLock() {
+			t.Fatal("lock not held during fn")
+		}
+	})
+	if !c.TryLock() {
+		t.Fatal("lock not released")
+	}
+}
+
+func TestWith2ReadWrite(t *testing.T) {
+	const iterations = 1000
+
+	var a, b RWMutex
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(swap bool) {
+			defer wg.Done()
+			x, y := &a, &b
+			if swap {
+				x, y = y, x
+			}
+			for j := 0; j < iterations; j++ {
+				With2ReadWrite(x, y, func() {})
+				With2Write(x, y, func() {})
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+
+	With2ReadWrite(&a, &b, func() {
+		if !a.TryRLock() {
+			t.Fatal("r not locked for reading")
+		}
+		a.RUnlock()
+		if b.TryRLock() {
+			t.Fatal("w not locked for writing")
+		}
+	})
+	if !a.TryLock() || !b.TryLock() {
+		t.Fatal("locks not released")
+	}
+}
+
+func TestWith2Panic(t *testing.T) {
+	var a, b Mutex
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic in fn not propagated")
+			}
+		}()
+		With2(&a, &b, func() { panic("fn") })
+	}()
+	if !a.TryLock() || !b.TryLock() {
+		t.Fatal("locks not released after panic")
+	}
+
+	var r, w RWMutex
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic in fn not propagated")
+			}
+		}()
+		With2ReadWrite(&r, &w, func() { panic("fn") })
+	}()
+	if !r.TryLock() || !w.TryLock() {
+		t.Fatal("locks not released after panic")
+	}
+}
+
+func TestReadWriteValue(t *testing.T) {
+	const iterations = 1000
+
+	var rw RWMutex
+	values := map[string]int{}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(write bool) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				if write {
+					WriteValue(&rw, func() int {
+						if rw.TryRLock() {
+							t.Error("read lock acquired during WriteValue")
+						}
+						values["n"]++
+						return values["n"]
+					})
+				} else if n := ReadValue(&rw, func() int { return values["n"] }); n < 0 {
+					t.Errorf("read invalid value %d", n)
+				}
+			}
+		}(i < 2)
+	}
+	wg.Wait()
+	if n := ReadValue(&rw, func() int { return values["n"] }); n != 2*iterations {
+		t.Fatalf("value is %d, expected %d", n, 2*iterations)
+	}
+
+	// Concurrent readers
+	inside := make(chan bool)
+	go ReadValue(&rw, func() bool { return <-inside })
+	if !ReadValue(&rw, func() bool {
+		inside <- true // Blocks until the other reader is inside as well
+		return true
+	}) {
+		t.Fatal("ReadValue did not return the read value")
+	}
+}
+
+func TestReadWriteValuePanic(t *testing.T) {
+	var rw RWMutex
+	for name, fn := range map[string]func(){
+		"ReadValue":  func() { ReadValue(&rw, func() int { panic("read") }) },
+		"WriteValue": func() { WriteValue(&rw, func() int { panic("write") }) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("panic in %s not propagated", name)
+				}
+			}()
+			fn()
+		}()
+		if !rw.TryLock() {
+			t.Fatalf("lock not released after panic in %s", name)
+		}
+		rw.Unlock()
+	}
+}
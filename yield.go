@@ -0,0 +1,19 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !tinygo && !spinlocksleepyield
+
+package spinlock
+
+import (
+	"runtime"
+)
+
+// yield yields the processor after a lock could not be acquired by busy
+// waiting, allowing other goroutines, e.g. the lock holder, to run.
+// With the gc runtime this is runtime.Gosched. See yield_sleep.go for
+// runtimes on which runtime.Gosched is unsuitable.
+func yield() {
+	runtime.Gosched()
+}
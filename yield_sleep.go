@@ -0,0 +1,27 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build tinygo || spinlocksleepyield
+
+package spinlock
+
+import (
+	"time"
+)
+
+// yieldSleep is the duration yield sleeps for.
+const yieldSleep = 50 * time.Microsecond
+
+// yield yields the processor after a lock could not be acquired by busy
+// waiting, allowing other goroutines, e.g. the lock holder, to run.
+//
+// With TinyGo, runtime.Gosched is a no-op when built without a scheduler
+// (-scheduler=none) and only switches between goroutines of its cooperative
+// scheduler otherwise, turning the spin loops into pure busy waiting which
+// monopolizes the processor. Thus yield sleeps briefly instead, which is used
+// for TinyGo builds and for builds with the spinlocksleepyield tag, e.g. for
+// other runtimes with the same problem.
+func yield() {
+	time.Sleep(yieldSleep)
+}
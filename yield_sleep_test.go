@@ -0,0 +1,31 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build spinlocksleepyield
+
+package spinlock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSleepYield(t *testing.T) {
+	start := time.Now()
+	yield()
+	if d := time.Since(start); d < yieldSleep {
+		t.Fatalf("yield returned after %v, expected to sleep for %v", d, yieldSleep)
+	}
+
+	// The locks still provide mutual exclusion
+	c := make(chan bool)
+	m := new(Mutex)
+	for i := 0; i < 4; i++ {
+		go HammerMutex(m, 1000, c)
+	}
+	for i := 0; i < 4; i++ {
+		<-c
+	}
+	HammerRWMutex(4, 4, 1000)
+}